@@ -0,0 +1,157 @@
+package dataurl
+
+import (
+	"encoding/base64"
+)
+
+// Base64Variant specifies the alphabet and padding used for base64
+// encoded payloads
+type Base64Variant int
+
+const (
+	// Base64Standard is the standard base64 encoding with padding, as
+	// defined in RFC 4648 section 4. This is the default.
+	Base64Standard Base64Variant = iota
+	// Base64RawStandard is the standard base64 encoding without padding
+	Base64RawStandard
+	// Base64URL is the URL-safe base64 encoding with padding, as
+	// defined in RFC 4648 section 5
+	Base64URL
+	// Base64RawURL is the URL-safe base64 encoding without padding
+	Base64RawURL
+	// Base64AutoDetect guesses the variant from the payload when parsing.
+	// When encoding, it is the same as Base64Standard.
+	Base64AutoDetect
+)
+
+func (v Base64Variant) encoding() *base64.Encoding {
+	switch v {
+	case Base64RawStandard:
+		return base64.RawStdEncoding
+	case Base64URL:
+		return base64.URLEncoding
+	case Base64RawURL:
+		return base64.RawURLEncoding
+	default:
+		return base64.StdEncoding
+	}
+}
+
+// appendBase64Encoded encodes data using enc, and appends the result to dst
+func appendBase64Encoded(enc *base64.Encoding, dst, data []byte) []byte {
+	n := enc.EncodedLen(len(data))
+	dst = grow(dst, n)
+	enc.Encode(dst[len(dst):len(dst)+n], data)
+	return dst[:len(dst)+n]
+}
+
+// detectBase64Encoding guesses the base64 variant used to encode data.
+// The URL-safe alphabet is assumed if data contains '-' or '_', and the
+// padding is assumed to be omitted if the length of data is not a multiple
+// of 4. If skipSpace is true, ASCII whitespace is not counted.
+// If the guess is wrong, decoding will fail.
+func detectBase64Encoding(data []byte, skipSpace bool) *base64.Encoding {
+	var urlSafe bool
+	var n int
+	var last byte
+	for _, c := range data {
+		if skipSpace && isSpace(c) {
+			continue
+		}
+		if c == '-' || c == '_' {
+			urlSafe = true
+		}
+		last = c
+		n++
+	}
+	raw := n%4 != 0 && last != '='
+
+	switch {
+	case urlSafe && raw:
+		return base64.RawURLEncoding
+	case urlSafe:
+		return base64.URLEncoding
+	case raw:
+		return base64.RawStdEncoding
+	default:
+		return base64.StdEncoding
+	}
+}
+
+// base64ChunkSize is the number of characters that are decoded at once
+// when whitespace is being skipped. It must be a multiple of 4.
+const base64ChunkSize = 1024
+
+// decodeBase64 decodes data into dst using enc, and returns the number
+// of bytes written. dst must be at least enc.DecodedLen(len(data)) bytes long.
+//
+// If skipSpace is true, ASCII whitespace in data is ignored. Instead of
+// compacting data into a copy first, the non-whitespace characters are
+// gathered into a small fixed-size buffer which is decoded whenever it
+// fills up. Errors are reported as base64.CorruptInputError, holding
+// the offset into data.
+func decodeBase64(enc *base64.Encoding, dst, data []byte, skipSpace bool) (int, error) {
+	if !skipSpace {
+		return enc.Decode(dst, data)
+	}
+
+	var chunk [base64ChunkSize]byte
+	var n, written int
+	var start int   // position in data of chunk[0]
+	var padded bool // true if a previous chunk ended with padding
+	flush := func() error {
+		m, err := enc.Decode(dst[written:], chunk[:n])
+		written += m
+		if err != nil {
+			if cie, ok := err.(base64.CorruptInputError); ok {
+				return base64.CorruptInputError(indexOfNonSpace(data, start, int(cie)))
+			}
+			return err
+		}
+		padded = n > 0 && chunk[n-1] == '='
+		n = 0
+		return nil
+	}
+
+	for i, c := range data {
+		if isSpace(c) {
+			continue
+		}
+		if padded {
+			// there must be nothing after the padding
+			return written, base64.CorruptInputError(i)
+		}
+		if n == 0 {
+			start = i
+		}
+		chunk[n] = c
+		n++
+		if n == len(chunk) {
+			if err := flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	if n > 0 {
+		if err := flush(); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// indexOfNonSpace returns the position in data of the n-th (0-based)
+// non-whitespace character found at or after start
+func indexOfNonSpace(data []byte, start, n int) int {
+	for i := start; i < len(data); i++ {
+		if isSpace(data[i]) {
+			continue
+		}
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(data)
+}
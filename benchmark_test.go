@@ -0,0 +1,146 @@
+package dataurl_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/lestrrat-go/dataurl"
+)
+
+var benchmarkImage = bytes.Repeat([]byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0xff}, 100)
+var benchmarkText = bytes.Repeat([]byte(`Hello, World! `), 100)
+
+func BenchmarkAppendEncode(b *testing.B) {
+	b.Run(`base64`, func(b *testing.B) {
+		options := []dataurl.EncodeOption{dataurl.WithMediaType(`image/png`)}
+		buf := make([]byte, 0, 4096)
+		b.ReportAllocs()
+		b.SetBytes(int64(len(benchmarkImage)))
+		for i := 0; i < b.N; i++ {
+			if _, err := dataurl.AppendEncode(buf[:0], benchmarkImage, options...); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run(`escaped`, func(b *testing.B) {
+		buf := make([]byte, 0, 4096)
+		b.ReportAllocs()
+		b.SetBytes(int64(len(benchmarkText)))
+		for i := 0; i < b.N; i++ {
+			if _, err := dataurl.AppendEncode(buf[:0], benchmarkText); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkEncode(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkImage)))
+	for i := 0; i < b.N; i++ {
+		if _, err := dataurl.Encode(benchmarkImage); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendDecode(b *testing.B) {
+	b.Run(`base64`, func(b *testing.B) {
+		src, err := dataurl.Encode(benchmarkImage, dataurl.WithMediaType(`image/png`))
+		if err != nil {
+			b.Fatal(err)
+		}
+		buf := make([]byte, 0, 4096)
+		b.ReportAllocs()
+		b.SetBytes(int64(len(src)))
+		for i := 0; i < b.N; i++ {
+			if _, _, err := dataurl.AppendDecode(buf[:0], src); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run(`escaped`, func(b *testing.B) {
+		src, err := dataurl.Encode(benchmarkText)
+		if err != nil {
+			b.Fatal(err)
+		}
+		buf := make([]byte, 0, 4096)
+		b.ReportAllocs()
+		b.SetBytes(int64(len(src)))
+		for i := 0; i < b.N; i++ {
+			if _, _, err := dataurl.AppendDecode(buf[:0], src); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run(`whatwg`, func(b *testing.B) {
+		src, err := dataurl.Encode(benchmarkImage, dataurl.WithMediaType(`image/png`))
+		if err != nil {
+			b.Fatal(err)
+		}
+		buf := make([]byte, 0, 4096)
+		b.ReportAllocs()
+		b.SetBytes(int64(len(src)))
+		for i := 0; i < b.N; i++ {
+			if _, _, err := dataurl.AppendDecode(buf[:0], src, dataurl.WithParseMode(dataurl.ParseModeWHATWG)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkParse(b *testing.B) {
+	src, err := dataurl.Encode(benchmarkImage, dataurl.WithMediaType(`image/png`))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(src)))
+	for i := 0; i < b.N; i++ {
+		if _, err := dataurl.Parse(src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	image := bytes.Repeat(benchmarkImage, 1000)
+	text := bytes.Repeat(benchmarkText, 1000)
+	testcases := []struct {
+		Name    string
+		Data    []byte
+		Options []dataurl.EncodeOption
+		Parse   []dataurl.ParseOption
+	}{
+		{Name: `base64`, Data: image, Options: []dataurl.EncodeOption{dataurl.WithMediaType(`image/png`)}},
+		{Name: `escaped`, Data: text},
+		{Name: `lenient`, Data: text, Parse: []dataurl.ParseOption{dataurl.WithParseMode(dataurl.ParseModeLenient)}},
+		{Name: `whatwg`, Data: image, Options: []dataurl.EncodeOption{dataurl.WithMediaType(`image/png`)}, Parse: []dataurl.ParseOption{dataurl.WithParseMode(dataurl.ParseModeWHATWG)}},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		b.Run(tc.Name, func(b *testing.B) {
+			src, err := dataurl.Encode(tc.Data, tc.Options...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			buf := make([]byte, 32*1024)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(src)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				dec := dataurl.NewDecoder(bytes.NewReader(src), tc.Parse...)
+				for {
+					_, err := dec.Read(buf)
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package dataurl
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// UnsupportedCharset is the error that is returned when the charset
+// parameter of a URL, or the charset given to WithCharset, names
+// a character encoding that is not supported
+type UnsupportedCharset struct {
+	// Charset is the name of the character encoding
+	Charset string
+}
+
+func (e *UnsupportedCharset) Error() string {
+	return fmt.Sprintf(`unsupported charset %q`, e.Charset)
+}
+
+// InvalidByteSequence is the error that is returned when the data of
+// a URL is not valid in the character encoding named by its charset
+// parameter
+type InvalidByteSequence struct {
+	// Charset is the value of the charset parameter, or US-ASCII
+	// if there is none
+	Charset string
+	// Offset is the position of the first invalid byte sequence in the data
+	Offset int
+}
+
+func (e *InvalidByteSequence) Error() string {
+	return fmt.Sprintf(`invalid %s byte sequence at byte %d`, e.Charset, e.Offset)
+}
+
+// UnrepresentableCharacter is the error that is returned when a character
+// in the data cannot be represented in the character encoding given
+// to WithCharset
+type UnrepresentableCharacter struct {
+	// Charset is the name of the character encoding
+	Charset string
+	// Rune is the character that cannot be represented
+	Rune rune
+	// Offset is the position of the character in the data
+	Offset int
+}
+
+func (e *UnrepresentableCharacter) Error() string {
+	return fmt.Sprintf(`character %q (%U) at byte %d cannot be represented in %s`, e.Rune, e.Rune, e.Offset, e.Charset)
+}
+
+// defaultCharset is the charset that is assumed when there is no charset
+// parameter, as specified in RFC 2397
+const defaultCharset = `US-ASCII`
+
+// charset converts text between a character encoding and UTF-8
+type charset struct {
+	// decode converts data to UTF-8, and returns the position of the
+	// first invalid byte sequence, or -1 if there is none
+	decode func(data []byte) (string, int)
+	// encode appends r in the character encoding to dst. It reports
+	// false if r cannot be represented.
+	encode func(dst []byte, r rune) ([]byte, bool)
+	// bom is the byte order mark that is written before encoded text
+	bom []byte
+}
+
+var (
+	charsetASCII       = &charset{decode: decodeASCII, encode: encodeASCII}
+	charsetUTF8        = &charset{decode: decodeUTF8, encode: encodeUTF8}
+	charsetLatin1      = &charset{decode: decodeLatin1, encode: encodeLatin1}
+	charsetWindows1252 = &charset{decode: decodeWindows1252, encode: encodeWindows1252}
+	charsetUTF16       = &charset{
+		decode: func(data []byte) (string, int) { return decodeUTF16(data, true, true) },
+		encode: encodeUTF16BE,
+		bom:    []byte{0xfe, 0xff},
+	}
+	charsetUTF16BE = &charset{
+		decode: func(data []byte) (string, int) { return decodeUTF16(data, true, false) },
+		encode: encodeUTF16BE,
+	}
+	charsetUTF16LE = &charset{
+		decode: func(data []byte) (string, int) { return decodeUTF16(data, false, false) },
+		encode: encodeUTF16LE,
+	}
+)
+
+// charsets maps the lower case names and aliases of the supported
+// character encodings, as registered with IANA, to their implementation
+var charsets = map[string]*charset{
+	`us-ascii`:       charsetASCII,
+	`ascii`:          charsetASCII,
+	`us`:             charsetASCII,
+	`iso646-us`:      charsetASCII,
+	`ansi_x3.4-1968`: charsetASCII,
+	`cp367`:          charsetASCII,
+	`ibm367`:         charsetASCII,
+	`utf-8`:          charsetUTF8,
+	`utf8`:           charsetUTF8,
+	`iso-8859-1`:     charsetLatin1,
+	`iso8859-1`:      charsetLatin1,
+	`iso_8859-1`:     charsetLatin1,
+	`latin1`:         charsetLatin1,
+	`l1`:             charsetLatin1,
+	`cp819`:          charsetLatin1,
+	`ibm819`:         charsetLatin1,
+	`windows-1252`:   charsetWindows1252,
+	`cp1252`:         charsetWindows1252,
+	`x-cp1252`:       charsetWindows1252,
+	`utf-16`:         charsetUTF16,
+	`utf-16be`:       charsetUTF16BE,
+	`utf-16le`:       charsetUTF16LE,
+}
+
+func lookupCharset(name string) *charset {
+	return charsets[strings.ToLower(strings.TrimSpace(name))]
+}
+
+// Text decodes u.Data into a string, according to the charset parameter
+// of u.MediaType. If there is no charset parameter, US-ASCII is assumed,
+// as specified in RFC 2397.
+//
+// The supported character encodings are US-ASCII, UTF-8, ISO-8859-1,
+// Windows-1252, UTF-16, UTF-16BE and UTF-16LE, along with their common
+// aliases. A leading byte order mark is removed from UTF-8 and UTF-16
+// data. UTF-16 data without a byte order mark is assumed to be big
+// endian, as specified in RFC 2781.
+//
+// A *UnsupportedCharset is returned if the character encoding is not
+// supported, and a *InvalidByteSequence if the data is not valid in it.
+func (u *URL) Text() (string, error) {
+	name, ok := lookupParam(u.MediaType.Params, `charset`)
+	if !ok {
+		name = defaultCharset
+	}
+
+	cs := lookupCharset(name)
+	if cs == nil {
+		return "", &UnsupportedCharset{Charset: name}
+	}
+
+	s, offset := cs.decode(u.Data)
+	if offset >= 0 {
+		return "", &InvalidByteSequence{Charset: name, Offset: offset}
+	}
+	return s, nil
+}
+
+func decodeASCII(data []byte) (string, int) {
+	for i, b := range data {
+		if b >= utf8.RuneSelf {
+			return "", i
+		}
+	}
+	return string(data), -1
+}
+
+func decodeUTF8(data []byte) (string, int) {
+	var start int
+	if len(data) >= 3 && data[0] == 0xef && data[1] == 0xbb && data[2] == 0xbf {
+		start = 3
+	}
+
+	for i := start; i < len(data); {
+		r, n := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && n == 1 {
+			return "", i
+		}
+		i += n
+	}
+	return string(data[start:]), -1
+}
+
+func decodeLatin1(data []byte) (string, int) {
+	var sb strings.Builder
+	sb.Grow(len(data))
+	for _, b := range data {
+		sb.WriteRune(rune(b))
+	}
+	return sb.String(), -1
+}
+
+// windows1252 maps the bytes from 0x80 to 0x9F, where Windows-1252
+// differs from ISO-8859-1, to runes. Zero means that the byte is undefined.
+var windows1252 = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+func decodeWindows1252(data []byte) (string, int) {
+	var sb strings.Builder
+	sb.Grow(len(data))
+	for i, b := range data {
+		r := rune(b)
+		if b >= 0x80 && b <= 0x9f {
+			if r = windows1252[b-0x80]; r == 0 {
+				return "", i
+			}
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String(), -1
+}
+
+// decodeUTF16 decodes UTF-16 data, in big endian byte order if bigEndian
+// is true. If detect is true, the byte order is taken from the byte order
+// mark, if any. A leading byte order mark is removed if it matches the
+// byte order.
+func decodeUTF16(data []byte, bigEndian, detect bool) (string, int) {
+	var start int
+	if len(data) >= 2 {
+		switch {
+		case data[0] == 0xfe && data[1] == 0xff && (bigEndian || detect):
+			bigEndian = true
+			start = 2
+		case data[0] == 0xff && data[1] == 0xfe && (!bigEndian || detect):
+			bigEndian = false
+			start = 2
+		}
+	}
+
+	unit := func(i int) rune {
+		if bigEndian {
+			return rune(data[i])<<8 | rune(data[i+1])
+		}
+		return rune(data[i+1])<<8 | rune(data[i])
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(data) - start)
+	for i := start; i < len(data); i += 2 {
+		if i+1 >= len(data) {
+			return "", i
+		}
+
+		r := unit(i)
+		if utf16.IsSurrogate(r) {
+			if i+3 >= len(data) {
+				return "", i
+			}
+			if r = utf16.DecodeRune(r, unit(i+2)); r == utf8.RuneError {
+				return "", i
+			}
+			i += 2
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String(), -1
+}
+
+// appendEncoded converts text from UTF-8 to cs, and appends the result
+// to dst. name is the name of cs, and offset is the position of text
+// in the data, both of which are used in errors.
+func (cs *charset) appendEncoded(dst, text []byte, name string, offset int) ([]byte, error) {
+	for i := 0; i < len(text); {
+		r, n := utf8.DecodeRune(text[i:])
+		if r == utf8.RuneError && n == 1 {
+			return dst, &InvalidByteSequence{Charset: `UTF-8`, Offset: offset + i}
+		}
+
+		var ok bool
+		if dst, ok = cs.encode(dst, r); !ok {
+			return dst, &UnrepresentableCharacter{Charset: name, Rune: r, Offset: offset + i}
+		}
+		i += n
+	}
+	return dst, nil
+}
+
+func encodeASCII(dst []byte, r rune) ([]byte, bool) {
+	if r >= utf8.RuneSelf {
+		return dst, false
+	}
+	return append(dst, byte(r)), true
+}
+
+func encodeUTF8(dst []byte, r rune) ([]byte, bool) {
+	var buf [utf8.UTFMax]byte
+	n := utf8.EncodeRune(buf[:], r)
+	return append(dst, buf[:n]...), true
+}
+
+func encodeLatin1(dst []byte, r rune) ([]byte, bool) {
+	if r > 0xff {
+		return dst, false
+	}
+	return append(dst, byte(r)), true
+}
+
+func encodeWindows1252(dst []byte, r rune) ([]byte, bool) {
+	if r < 0x80 || (r >= 0xa0 && r <= 0xff) {
+		return append(dst, byte(r)), true
+	}
+	for i, v := range windows1252 {
+		if v != 0 && v == r {
+			return append(dst, byte(0x80+i)), true
+		}
+	}
+	return dst, false
+}
+
+func encodeUTF16BE(dst []byte, r rune) ([]byte, bool) {
+	if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+		return append(dst, byte(r1>>8), byte(r1), byte(r2>>8), byte(r2)), true
+	}
+	return append(dst, byte(r>>8), byte(r)), true
+}
+
+func encodeUTF16LE(dst []byte, r rune) ([]byte, bool) {
+	if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+		return append(dst, byte(r1), byte(r1>>8), byte(r2), byte(r2>>8)), true
+	}
+	return append(dst, byte(r), byte(r>>8)), true
+}
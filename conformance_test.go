@@ -0,0 +1,188 @@
+package dataurl_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/dataurl"
+	"github.com/stretchr/testify/require"
+)
+
+// conformanceVector is a single test vector from the files in testdata.
+//
+// The files follow the same format as the vectors found in
+// web-platform-tests (fetch/data-urls/resources/*.json), with an extra
+// element listing the parse modes under which the vector is expected
+// to produce the given result. Elements that are plain strings are comments.
+type conformanceVector struct {
+	Input     string
+	MediaType *string // nil if parsing is expected to fail
+	Body      []byte
+	Modes     []string
+}
+
+var parseModes = map[string]dataurl.ParseMode{
+	`default`: dataurl.ParseModeDefault,
+	`strict`:  dataurl.ParseModeStrict,
+	`lenient`: dataurl.ParseModeLenient,
+	`whatwg`:  dataurl.ParseModeWHATWG,
+}
+
+func loadConformanceVectors(t *testing.T, filename string, base64Only bool) []conformanceVector {
+	t.Helper()
+
+	buf, err := os.ReadFile(filepath.Join(`testdata`, filename))
+	require.NoError(t, err, `os.ReadFile should succeed`)
+
+	var list []json.RawMessage
+	require.NoError(t, json.Unmarshal(buf, &list), `json.Unmarshal should succeed`)
+
+	var vectors []conformanceVector
+	for _, raw := range list {
+		var comment string
+		if json.Unmarshal(raw, &comment) == nil {
+			continue
+		}
+
+		var elements []json.RawMessage
+		require.NoError(t, json.Unmarshal(raw, &elements), `json.Unmarshal should succeed`)
+
+		// the parse modes are always the last element
+		var v conformanceVector
+		require.NoError(t, json.Unmarshal(elements[0], &v.Input), `json.Unmarshal should succeed`)
+		require.NoError(t, json.Unmarshal(elements[len(elements)-1], &v.Modes), `json.Unmarshal should succeed`)
+		elements = elements[1 : len(elements)-1]
+
+		if base64Only {
+			// base64.json does not contain media types, because
+			// the input is always used as the payload for "data:;base64,"
+			v.Input = `data:;base64,` + v.Input
+			if string(elements[0]) != `null` {
+				elements = append([]json.RawMessage{json.RawMessage(`"text/plain;charset=US-ASCII"`)}, elements...)
+			}
+		}
+
+		require.NoError(t, json.Unmarshal(elements[0], &v.MediaType), `json.Unmarshal should succeed`)
+		if v.MediaType != nil {
+			var body []int
+			require.NoError(t, json.Unmarshal(elements[1], &body), `json.Unmarshal should succeed`)
+			for _, b := range body {
+				v.Body = append(v.Body, byte(b))
+			}
+		}
+
+		require.NotEmpty(t, v.Modes, `vector %q should declare at least one parse mode`, v.Input)
+		vectors = append(vectors, v)
+	}
+	return vectors
+}
+
+// parseSerializedMediaType splits a media type as serialized by
+// the WHATWG MIME Sniffing standard into its essence and parameters
+func parseSerializedMediaType(s string) (string, map[string]string) {
+	params := make(map[string]string)
+
+	i := strings.IndexByte(s, ';')
+	if i < 0 {
+		return s, params
+	}
+	typ := s[:i]
+	s = s[i+1:]
+
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '=')
+		name := s[:i]
+		s = s[i+1:]
+
+		var value strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			s = s[1:]
+			for len(s) > 0 && s[0] != '"' {
+				if s[0] == '\\' {
+					s = s[1:]
+				}
+				value.WriteByte(s[0])
+				s = s[1:]
+			}
+			s = strings.TrimPrefix(s[1:], `;`)
+		} else {
+			i := strings.IndexByte(s, ';')
+			if i < 0 {
+				i = len(s)
+			}
+			value.WriteString(s[:i])
+			s = strings.TrimPrefix(s[i:], `;`)
+		}
+		params[name] = value.String()
+	}
+	return typ, params
+}
+
+func runConformanceVectors(t *testing.T, filename string, base64Only bool) {
+	for _, v := range loadConformanceVectors(t, filename, base64Only) {
+		v := v
+		for _, name := range v.Modes {
+			mode, ok := parseModes[name]
+			require.True(t, ok, `vector %q declares unknown parse mode %q`, v.Input, name)
+			t.Run(fmt.Sprintf(`%s/%q`, name, v.Input), func(t *testing.T) {
+				u, err := dataurl.Parse([]byte(v.Input), dataurl.WithParseMode(mode))
+				if v.MediaType == nil {
+					require.Error(t, err, `dataurl.Parse should fail`)
+				} else {
+					require.NoError(t, err, `dataurl.Parse should succeed`)
+					checkConformanceResult(t, v, u.MediaType, u.Data)
+				}
+
+				dec := dataurl.NewDecoder(strings.NewReader(v.Input), dataurl.WithParseMode(mode))
+				mt, err := dec.MediaType()
+				if err == nil {
+					var data []byte
+					data, err = io.ReadAll(dec)
+					if err == nil {
+						require.NotNil(t, v.MediaType, `dataurl.Decoder should fail`)
+						checkConformanceResult(t, v, mt, data)
+					}
+				}
+				if v.MediaType == nil {
+					require.Error(t, err, `dataurl.Decoder should fail`)
+				} else {
+					require.NoError(t, err, `dataurl.Decoder should succeed`)
+				}
+			})
+		}
+	}
+}
+
+func checkConformanceResult(t *testing.T, v conformanceVector, mt dataurl.MediaType, data []byte) {
+	t.Helper()
+
+	typ, params := parseSerializedMediaType(*v.MediaType)
+	require.Equal(t, typ, mt.Type, `media types should match`)
+	require.Equal(t, len(params), len(mt.Params), `number of parameters should match`)
+	for name, value := range params {
+		require.Equal(t, value, mt.Params[name], `parameter %q should match`, name)
+	}
+
+	if len(v.Body) == 0 {
+		require.Empty(t, data, `data should be empty`)
+	} else {
+		require.Equal(t, v.Body, data, `data should match`)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	t.Run(`data-urls.json`, func(t *testing.T) {
+		runConformanceVectors(t, `data-urls.json`, false)
+	})
+	t.Run(`base64.json`, func(t *testing.T) {
+		runConformanceVectors(t, `base64.json`, true)
+	})
+	t.Run(`rfc2397.json`, func(t *testing.T) {
+		runConformanceVectors(t, `rfc2397.json`, false)
+	})
+}
@@ -5,28 +5,34 @@ package dataurl
 import (
 	"bytes"
 	"encoding/base64"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"mime"
-	"net/http"
 	"strings"
 )
 
-// MediaType holds the relevant type information for the data.
-type MediaType struct {
-	Type   string
-	Params map[string]string
-}
-
 // URL represents a data URL structure.
 type URL struct {
 	MediaType MediaType
 	Data      []byte
+	// Base64 is true if the payload is base64 encoded. When the URL is
+	// serialized, it determines whether base64 or percent-escapes are used.
+	Base64 bool
+	// Header is the section of the original input between the scheme and
+	// the comma, exactly as it appeared. It is set by Parse for reference
+	// when WithPreserveInput is enabled, and is not used when the URL
+	// is serialized.
+	Header string
+
+	// source is the state of the URL right after it was parsed, if
+	// WithPreserveInput is enabled
+	source *source
 }
 
 var scheme = []byte(`data:`)
 var base64Marker = []byte(`;base64`)
-var b64enc = base64.StdEncoding
+
+const upperHex = `0123456789ABCDEF`
 
 func defaultMediaType() MediaType {
 	return MediaType{
@@ -37,246 +43,764 @@ func defaultMediaType() MediaType {
 	}
 }
 
+// ParseMode specifies the algorithm that Parse uses to interpret its input
+type ParseMode int
+
+const (
+	// ParseModeDefault is the historical behavior of this library, which
+	// is neither fully strict nor fully lenient.
+	ParseModeDefault ParseMode = iota
+	// ParseModeStrict only accepts data URLs that conform exactly
+	// to the RFC 2397 grammar.
+	ParseModeStrict
+	// ParseModeLenient accepts what web browsers typically accept, while
+	// still using the media type parsing rules of this library.
+	ParseModeLenient
+	// ParseModeWHATWG implements the "data: URL processor" algorithm
+	// as specified in the WHATWG Fetch standard, and therefore produces
+	// the same result as web browsers do.
+	ParseModeWHATWG
+)
+
 // Parse takes a data URL as a sequence of bytes and parses it into
 // `*dataurl.URL` object.
 //
-func Parse(data []byte) (*URL, error) {
-	if !bytes.HasPrefix(data, scheme) {
-		return nil, fmt.Errorf(`invalid scheme`)
+// By default the parser uses the historical behavior of this library.
+// Use `dataurl.WithStrict()` to either only accept input that conforms
+// exactly to RFC 2397, or to accept input in the same manner as web
+// browsers do. Use `dataurl.WithParseMode(dataurl.ParseModeWHATWG)` to
+// obtain the exact same result as a browser implementing the WHATWG
+// Fetch standard would.
+//
+// Use `dataurl.WithPreserveInput()` to have the returned URL remember
+// the original input, so that serializing it using
+// `(*dataurl.URL).String()` or `MarshalText()` reproduces the input
+// exactly, unless the URL has been modified.
+func Parse(data []byte, options ...ParseOption) (*URL, error) {
+	p := newParser(options)
+	u, err := p.parse(data)
+	if err != nil {
+		return nil, err
 	}
-	data = data[len(scheme):]
+	if p.preserveInput {
+		u.record(p, data)
+	}
+	return u, nil
+}
 
-	switch {
-	case len(data) < 1:
-		return nil, fmt.Errorf(`invalid data URL (no data)`)
-	case data[0] == ',': // data:,xxxxx
-		return parseData(defaultMediaType(), false, data)
-	case data[0] == ';': // data:;base64,xxxxx
-		return parseBase64Marker(defaultMediaType(), data)
-	default:
-		// data:.....;foo=bar;base64....
-		i := bytes.Index(data, base64Marker)
-		var parseBase64 bool
-		if i > 0 {
-			parseBase64 = true
-		} else {
-			i = bytes.IndexByte(data, ',')
-		}
+// AppendDecode is like Parse, but appends the decoded payload to dst and
+// returns the extended buffer. If an error occurs, dst is returned as is.
+//
+// To avoid allocating memory, the media type is validated in place
+// rather than parsed, and the same errors as Parse are reported.
+// Instead of a MediaType, the first return value is the section of src
+// between the scheme and the comma with the ";base64" marker removed.
+// It may be parsed separately when needed. However, if a Policy is
+// given using `dataurl.WithPolicy()`, the media type is parsed in order
+// to enforce it.
+//
+// Except when the payload must be normalized before being decoded
+// (i.e. base64 payloads containing escaped sequences in the lenient
+// mode, or any payload in the WHATWG mode), or when the media type
+// contains RFC 2231 parameters or non-ASCII text in the default mode,
+// no memory is allocated when dst has enough capacity to hold the result.
+func AppendDecode(dst, src []byte, options ...ParseOption) ([]byte, []byte, error) {
+	var p parser
+	p.apply(options)
+	if p.mode == ParseModeWHATWG {
+		mediaType, _, dst, err := p.appendWHATWG(dst, src)
+		return mediaType, dst, err
+	}
 
-		if i < 0 {
-			return nil, fmt.Errorf(`invalid data URL (no data)`)
-		}
+	if err := p.checkEncodedLength(src); err != nil {
+		return nil, dst, err
+	}
+
+	start, err := p.skipScheme(src)
+	if err != nil {
+		return nil, dst, err
+	}
 
-		// The parsing logic for media type parameters is curretly completely
-		// defered to "mime.ParseMEdiaType()". If it can parse it, then we think
-		// it's valid -- but we _DO_ unescape attribute keys anr values
-		// if they contain % signs. I don't know, it looks weird, but we'll go with this for now
+	header, payload, ok := splitHeader(src[start:])
+	if !ok {
+		return nil, dst, newParseError(KindNoData, len(src), nil)
+	}
+	if err := p.checkHeader(header, start); err != nil {
+		return nil, dst, err
+	}
 
-		typ, params, err := mime.ParseMediaType(string(data[:i]))
+	mediaType, isBase64 := splitBase64Marker(header, p.mode)
+	if p.policy != nil {
+		mt, _, err := p.parseHeader(header, start)
 		if err != nil {
-			return nil, fmt.Errorf(`failed to parse media type %q`, data[:i])
+			return nil, dst, err
 		}
+		if err := p.checkPolicy(mt, header, start); err != nil {
+			return nil, dst, err
+		}
+	} else if err := p.checkMediaType(mediaType, start); err != nil {
+		return nil, dst, err
+	}
 
-		for k, v := range params {
-			unescapedKey, err := unescape([]byte(k), false)
-			if err != nil {
-				return nil, fmt.Errorf(`failed to unescape parameter key %q: %w`, k, err)
-			}
+	if err := p.checkDecodedLength(payload, len(src)-len(payload), isBase64); err != nil {
+		return nil, dst, err
+	}
+	dst, err = p.appendPayload(dst, payload, len(src)-len(payload), isBase64)
+	if err != nil {
+		return nil, dst, err
+	}
+	return mediaType, dst, nil
+}
 
-			unescapedValue, err := unescape([]byte(v), false)
-			if err != nil {
-				return nil, fmt.Errorf(`failed to unescape parameter value for %q: %w`, k, err)
-			}
+// parser holds the configuration for a single invocation of Parse
+type parser struct {
+	mode        ParseMode
+	variant     Base64Variant
+	ignoreSpace bool
+	policy      *Policy
+	maxEncoded  int
+	maxDecoded  int
+	maxHeader   int
+	maxParams   int
+	verify      bool
+	verifier    verifyConfig
+
+	preserveInput bool
+}
 
-			if uks := string(unescapedKey); uks != k {
-				delete(params, k)
-				params[uks] = string(unescapedValue)
-				continue
-			}
+func newParser(options []ParseOption) *parser {
+	var p parser
+	p.apply(options)
+	return &p
+}
 
-			if uvs := string(unescapedValue); v != uvs {
-				params[k] = uvs
+func (p *parser) apply(options []ParseOption) {
+	var ignoreSpaceSet bool
+	for _, option := range options {
+		switch option.Ident() {
+		case identParseMode{}:
+			p.mode = option.Value().(ParseMode)
+		case identStrict{}:
+			if option.Value().(bool) {
+				p.mode = ParseModeStrict
+			} else {
+				p.mode = ParseModeLenient
 			}
+		case identBase64Variant{}:
+			p.variant = option.Value().(Base64Variant)
+		case identIgnoreWhitespace{}:
+			p.ignoreSpace = option.Value().(bool)
+			ignoreSpaceSet = true
+		case identPolicy{}:
+			p.policy = option.Value().(*Policy)
+		case identMaxEncodedLength{}:
+			p.maxEncoded = option.Value().(int)
+		case identMaxDecodedLength{}:
+			p.maxDecoded = option.Value().(int)
+		case identMaxHeaderLength{}:
+			p.maxHeader = option.Value().(int)
+		case identMaxParams{}:
+			p.maxParams = option.Value().(int)
+		case identVerify{}:
+			p.verify = option.Value().(bool)
+		case identTolerances{}:
+			p.verifier.tolerances = option.Value().([]Tolerance)
+			p.verifier.tolerancesSet = true
+		case identSniffer{}:
+			p.verifier.sniffer = option.Value().(Sniffer)
+		case identPreserveInput{}:
+			p.preserveInput = option.Value().(bool)
 		}
+	}
 
-		data = data[i:]
+	if !ignoreSpaceSet {
+		p.ignoreSpace = p.mode == ParseModeLenient
+	}
+}
+
+func (p *parser) parse(data []byte) (*URL, error) {
+	u, err := p.parseURL(data)
+	if err != nil {
+		return nil, err
+	}
 
-		mt := MediaType{
-			Type:   typ,
-			Params: params,
+	if p.verify {
+		if err := p.verifier.verify(u.MediaType, u.Data); err != nil {
+			return nil, newParseError(KindMediaTypeMismatch, bytes.IndexByte(data, ':')+1, err)
 		}
+	}
+	return u, nil
+}
+
+// parseURL parses data without verifying its content
+func (p *parser) parseURL(data []byte) (*URL, error) {
+	if p.mode == ParseModeWHATWG {
+		return p.parseWHATWG(data)
+	}
+
+	if err := p.checkEncodedLength(data); err != nil {
+		return nil, err
+	}
+
+	start, err := p.skipScheme(data)
+	if err != nil {
+		return nil, err
+	}
+
+	header, payload, ok := splitHeader(data[start:])
+	if !ok {
+		return nil, newParseError(KindNoData, len(data), nil)
+	}
+	payloadOffset := len(data) - len(payload)
+
+	if err := p.checkHeader(header, start); err != nil {
+		return nil, err
+	}
+
+	mt, isBase64, err := p.parseHeader(header, start)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkPolicy(mt, header, start); err != nil {
+		return nil, err
+	}
+	if err := p.checkDecodedLength(payload, payloadOffset, isBase64); err != nil {
+		return nil, err
+	}
+
+	decoded, err := p.appendPayload(nil, payload, payloadOffset, isBase64)
+	if err != nil {
+		return nil, err
+	}
 
-		if parseBase64 {
-			return parseBase64Marker(mt, data)
-		} else {
-			return parseData(mt, false, data)
+	return &URL{
+		MediaType: mt,
+		Data:      decoded,
+		Base64:    isBase64,
+	}, nil
+}
+
+// skipScheme verifies that data starts with the scheme, and returns the
+// position immediately after it. The lenient mode allows leading whitespace.
+func (p *parser) skipScheme(data []byte) (int, error) {
+	switch p.mode {
+	case ParseModeDefault:
+		if !bytes.HasPrefix(data, scheme) {
+			return 0, newParseError(KindInvalidScheme, 0, nil)
+		}
+		return len(scheme), nil
+	case ParseModeLenient:
+		start := len(data) - len(trimLeftSpace(data))
+		if !hasScheme(data[start:]) {
+			return 0, newParseError(KindInvalidScheme, start, nil)
+		}
+		return start + len(scheme), nil
+	default:
+		if !hasScheme(data) {
+			return 0, newParseError(KindInvalidScheme, 0, nil)
 		}
+		return len(scheme), nil
 	}
 }
 
-func parseBase64Marker(mediaType MediaType, data []byte) (*URL, error) {
-	if !bytes.HasPrefix(data, base64Marker) {
-		return nil, fmt.Errorf(`invalid data URL (invalid base64 marker)`)
+// parseHeader parses the header section of a data URL (the media type
+// followed by the optional ";base64" marker), and reports whether the
+// payload is base64 encoded. offset is the position of header within
+// the original input.
+func (p *parser) parseHeader(header []byte, offset int) (MediaType, bool, error) {
+	header, isBase64 := splitBase64Marker(header, p.mode)
+
+	var mt MediaType
+	switch p.mode {
+	case ParseModeStrict:
+		parsed, err := parseStrictMediaType(header, offset)
+		if err != nil {
+			return MediaType{}, false, err
+		}
+		mt = parsed
+	case ParseModeLenient:
+		mt = parseLenientMediaType(header)
+	default:
+		parsed, err := parseDefaultMediaType(header, offset)
+		if err != nil {
+			return MediaType{}, false, err
+		}
+		mt = parsed
 	}
-	return parseData(mediaType, true, data[len(base64Marker):])
+
+	// the names have been unescaped successfully, if at all
+	mt.recordOrder(header, unescapeLenient)
+	return mt, isBase64, nil
 }
 
-func parseData(mediaType MediaType, isBase64 bool, data []byte) (*URL, error) {
-	if len(data) < 2 || data[0] != ',' {
-		return nil, fmt.Errorf(`invalid data URL (invalid data section)`)
+// parseDefaultMediaType parses the media type section of a data URL
+// in the default mode. offset is the position of header within the
+// original input.
+func parseDefaultMediaType(header []byte, offset int) (MediaType, error) {
+	if len(header) == 0 {
+		return defaultMediaType(), nil
 	}
-	data = data[1:]
 
-	ret := URL{
-		MediaType: mediaType,
+	// The parsing logic for media type parameters is curretly completely
+	// defered to "mime.ParseMEdiaType()". If it can parse it, then we think
+	// it's valid -- but we _DO_ unescape attribute keys anr values
+	// if they contain % signs. I don't know, it looks weird, but we'll go with this for now
+	typ, params, err := mime.ParseMediaType(string(header))
+	if err != nil {
+		return MediaType{}, newParseError(KindInvalidMediaType, offset, fmt.Errorf(`failed to parse media type %q: %w`, header, err))
 	}
-	if !isBase64 {
-		unescaped, err := unescape(data, true)
+
+	params, err = unescapeParams(params, offset)
+	if err != nil {
+		return MediaType{}, err
+	}
+
+	return MediaType{
+		Type:   typ,
+		Params: params,
+	}, nil
+}
+
+// unescapeParams returns a copy of params with the attribute keys and
+// values unescaped. As the parameters have already been extracted from
+// the input, any error is reported at offset, which should point to the
+// start of the media type.
+func unescapeParams(params map[string]string, offset int) (map[string]string, error) {
+	unescaped := make(map[string]string, len(params))
+	for k, v := range params {
+		key, err := unescape([]byte(k), offset, nil)
+		if err != nil {
+			return nil, atOffset(err, offset)
+		}
+
+		value, err := unescape([]byte(v), offset, nil)
 		if err != nil {
-			return nil, fmt.Errorf(`invalid data URL (failed to escape data: %w)`, err)
+			return nil, atOffset(err, offset)
+		}
+
+		if _, ok := unescaped[string(key)]; ok {
+			return nil, newParseError(KindInvalidMediaType, offset, fmt.Errorf(`duplicate parameter %q`, key))
 		}
-		ret.Data = unescaped
+		unescaped[string(key)] = string(value)
+	}
+	return unescaped, nil
+}
+
+// hasScheme returns true if data starts with "data:". The scheme
+// is matched case-insensitively.
+func hasScheme(data []byte) bool {
+	return len(data) >= len(scheme) && bytes.EqualFold(data[:len(scheme)], scheme)
+}
+
+// splitHeader splits data, which must not include the scheme, at the
+// first comma into the header section (the media type followed by the
+// optional ";base64" marker) and the payload. The last return value
+// is false if data does not contain a comma.
+func splitHeader(data []byte) ([]byte, []byte, bool) {
+	i := bytes.IndexByte(data, ',')
+	if i < 0 {
+		return nil, nil, false
+	}
+	return data[:i], data[i+1:], true
+}
+
+// indexSeparator returns the index of the first ';' in header that is
+// not inside a quoted string, or -1 if there is none. As separators are
+// never inside quoted strings, the rest of header after a separator can
+// be passed to indexSeparator to find the next one.
+func indexSeparator(header []byte) int {
+	var quoted bool
+	for i := 0; i < len(header); i++ {
+		switch c := header[i]; {
+		case quoted && c == '\\':
+			i++ // skip the escaped character
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == ';':
+			return i
+		}
+	}
+	return -1
+}
+
+// lastSeparator returns the index of the ';' that precedes the last
+// token in header, or -1 if header consists of a single token.
+func lastSeparator(header []byte) int {
+	last := -1
+	for {
+		i := indexSeparator(header[last+1:])
+		if i < 0 {
+			return last
+		}
+		last += i + 1
+	}
+}
+
+// splitBase64Marker checks if the last token in header is exactly
+// "base64". If so, the header without the marker is returned along
+// with true.
+//
+// The historical parser matches the marker case-sensitively. Other modes
+// match it case-insensitively, and the lenient mode additionally
+// ignores surrounding whitespace.
+func splitBase64Marker(header []byte, mode ParseMode) ([]byte, bool) {
+	i := lastSeparator(header)
+	if i < 0 {
+		return header, false
+	}
+
+	marker := header[i+1:]
+	switch mode {
+	case ParseModeDefault:
+		if !bytes.Equal(marker, base64Marker[1:]) {
+			return header, false
+		}
+	case ParseModeLenient:
+		marker = trimSpace(marker)
+		fallthrough
+	default:
+		if !bytes.EqualFold(marker, base64Marker[1:]) {
+			return header, false
+		}
+	}
+	return header[:i], true
+}
+
+// parseStrictMediaType parses the media type section of a data URL
+// (the part between "data:" and the optional ";base64" marker) using
+// the token grammar from RFC 2045. offset is the position of header
+// within the original input.
+func parseStrictMediaType(header []byte, offset int) (MediaType, error) {
+	if len(header) == 0 {
+		return defaultMediaType(), nil
+	}
+
+	tokens := bytes.Split(header, []byte{';'})
+
+	mt := MediaType{Params: map[string]string{}}
+	if len(tokens[0]) == 0 {
+		// RFC 2397: "text/plain" can be omitted but the charset
+		// parameter supplied
+		mt.Type = `text/plain`
 	} else {
-		dst := make([]byte, b64enc.DecodedLen(len(data)))
-		n, err := b64enc.Decode(dst, data)
+		typ, err := unescape(tokens[0], offset, isURLChar)
 		if err != nil {
-			return nil, fmt.Errorf(`invalid data URL (base64: %w, %q)`, err, data)
+			return MediaType{}, err
+		}
+
+		i := bytes.IndexByte(typ, '/')
+		if i < 0 || !isToken(typ[:i]) || !isToken(typ[i+1:]) {
+			return MediaType{}, newParseError(KindInvalidMediaType, offset, fmt.Errorf(`invalid media type %q`, typ))
 		}
-		ret.Data = dst[:n]
+		mt.Type = strings.ToLower(string(typ))
+	}
+	offset += len(tokens[0]) + 1
+
+	for _, token := range tokens[1:] {
+		i := bytes.IndexByte(token, '=')
+		if i < 0 {
+			return MediaType{}, newParseError(KindInvalidMediaType, offset, fmt.Errorf(`invalid media type parameter %q`, token))
+		}
+
+		key, err := unescape(token[:i], offset, isURLChar)
+		if err != nil {
+			return MediaType{}, err
+		}
+		if !isToken(key) {
+			return MediaType{}, newParseError(KindInvalidMediaType, offset, fmt.Errorf(`invalid parameter key %q`, key))
+		}
+
+		value, err := unescape(token[i+1:], offset+i+1, isURLChar)
+		if err != nil {
+			return MediaType{}, err
+		}
+		if !isToken(value) {
+			unquoted, err := unquote(value)
+			if err != nil {
+				return MediaType{}, newParseError(KindInvalidMediaType, offset+i+1, fmt.Errorf(`invalid parameter value for %q: %w`, key, err))
+			}
+			value = unquoted
+		}
+
+		mt.Params[strings.ToLower(string(key))] = string(value)
+		offset += len(token) + 1
+	}
+	return mt, nil
+}
+
+// parseLenientMediaType parses the media type section of a data URL.
+// If the media type cannot be parsed, the default media type is returned.
+func parseLenientMediaType(header []byte) MediaType {
+	header = trimSpace(header)
+	if len(header) == 0 {
+		return defaultMediaType()
+	}
+
+	s := string(header)
+	if s[0] == ';' {
+		s = `text/plain` + s
+	}
+
+	typ, params, err := mime.ParseMediaType(s)
+	if err != nil {
+		return defaultMediaType()
+	}
+
+	unescaped := make(map[string]string, len(params))
+	for k, v := range params {
+		key := string(unescapeLenient([]byte(k)))
+		if _, ok := unescaped[key]; ok {
+			// parameters that are the same once unescaped are duplicates
+			return defaultMediaType()
+		}
+		unescaped[key] = string(unescapeLenient([]byte(v)))
+	}
+
+	return MediaType{
+		Type:   typ,
+		Params: unescaped,
 	}
-	return &ret, nil
 }
 
-func unescape(data []byte, strict bool) ([]byte, error) {
-	var base [1]byte
-	var dst bytes.Buffer
-	var buf = base[:1]
+// appendPayload decodes the payload of a data URL and appends the result
+// to dst. offset is the position of payload within the original input.
+func (p *parser) appendPayload(dst, payload []byte, offset int, isBase64 bool) ([]byte, error) {
+	switch p.mode {
+	case ParseModeStrict:
+		if !isBase64 {
+			return appendUnescaped(dst, payload, offset, isURLChar)
+		}
 
-	l := len(data)
-	max := l - 1
-	dst.Grow(l) // even with no escape, it's going to be _around_ the same size as the origianl data
-	for i := 0; i < l; i++ {
+		// all of the characters in the base64 alphabet are valid URL characters,
+		// so we let the decoder find invalid characters
+		return p.appendBase64(dst, payload, offset)
+	case ParseModeLenient:
+		payload = trimRightSpace(payload)
+		if !isBase64 {
+			return appendUnescapedLenient(dst, payload), nil
+		}
+
+		unescaped := payload
+		if bytes.IndexByte(payload, '%') > -1 {
+			unescaped = unescapeLenient(payload)
+		}
+
+		decoded, err := p.appendBase64(dst, unescaped, offset)
+		if err != nil {
+			if len(unescaped) != len(payload) {
+				// escaped sequences were decoded, so the reported
+				// position is no longer accurate
+				err = atOffset(err, offset)
+			}
+			return dst, err
+		}
+		return decoded, nil
+	default:
+		if len(payload) < 1 {
+			return dst, newParseError(KindNoData, offset, nil)
+		}
+
+		if !isBase64 {
+			return appendUnescaped(dst, payload, offset, isNotReserved)
+		}
+		return p.appendBase64(dst, payload, offset)
+	}
+}
+
+// appendBase64 decodes data using the base64 variant specified by the
+// user, and appends the result to dst. offset is the position of data
+// within the original input.
+func (p *parser) appendBase64(dst, data []byte, offset int) ([]byte, error) {
+	enc := p.variant.encoding()
+	if p.variant == Base64AutoDetect {
+		enc = detectBase64Encoding(data, p.ignoreSpace)
+	}
+
+	dst = grow(dst, enc.DecodedLen(len(data)))
+	n, err := decodeBase64(enc, dst[len(dst):cap(dst)], data, p.ignoreSpace)
+	if err != nil {
+		var cie base64.CorruptInputError
+		if errors.As(err, &cie) {
+			offset += int(cie)
+		}
+		return dst, newParseError(KindInvalidBase64, offset, err)
+	}
+	return dst[:len(dst)+n], nil
+}
+
+// grow ensures that there is room for at least n more bytes in dst
+func grow(dst []byte, n int) []byte {
+	if cap(dst)-len(dst) >= n {
+		return dst
+	}
+	return append(dst, make([]byte, n)...)[:len(dst)]
+}
+
+// unescape decodes percent-escaped sequences in data. If allowed is
+// non-nil, any unescaped byte for which allowed returns false is
+// reported as an error. offset is the position of data within the
+// original input.
+func unescape(data []byte, offset int, allowed func(byte) bool) ([]byte, error) {
+	// even with no escape, it's going to be _around_ the same size as the origianl data
+	return appendUnescaped(make([]byte, 0, len(data)), data, offset, allowed)
+}
+
+// appendUnescaped is like unescape, but appends the result to dst
+func appendUnescaped(dst, data []byte, offset int, allowed func(byte) bool) ([]byte, error) {
+	if err := checkEscapes(data, offset, allowed); err != nil {
+		return dst, err
+	}
+	// as every escaped sequence is valid, the lenient decoding is exact
+	return appendUnescapedLenient(dst, data), nil
+}
+
+// checkEscapes reports the first error that unescape would return for
+// data, without decoding it
+func checkEscapes(data []byte, offset int, allowed func(byte) bool) error {
+	for i := 0; i < len(data); i++ {
 		switch c := data[i]; c {
 		case '%':
-			if i > max-2 { // need two more bytes
-				return nil, fmt.Errorf(`failed to unescape: unexpected end of byte sequence at byte %d`, i)
+			if i+2 >= len(data) { // need two more bytes
+				return newParseError(KindInvalidEscape, offset+i, fmt.Errorf(`unexpected end of byte sequence`))
 			}
 
-			if _, err := hex.Decode(buf, data[i+1:i+3]); err != nil {
-				return nil, fmt.Errorf(`failed to unescape: invalid hexadecimal sequence starting at byte %d`, i)
+			if !isHex(data[i+1]) || !isHex(data[i+2]) {
+				return newParseError(KindInvalidEscape, offset+i, fmt.Errorf(`invalid hexadecimal sequence %q`, data[i:i+3]))
 			}
-			dst.Write(buf)
 			i += 2
 		default:
-			if !strict {
-				dst.WriteByte(c)
-				continue
-			}
-
-			if isNotReserved(c) {
-				dst.WriteByte(c)
+			if allowed == nil || allowed(c) {
 				continue
 			}
 
-			return nil, fmt.Errorf(`failed to unescape: reserved character %q found at byte %d`, c, i)
+			return newParseError(KindReservedCharacter, offset+i, fmt.Errorf(`%q must be percent-escaped`, c))
 		}
 	}
-	return dst.Bytes(), nil
+	return nil
 }
 
-// Encode encodes a piece of data into data URL format.
-//
-// By default this function auto-detects the content of the given piece of
-// data using `"net/http".DetectContentType`.
-//
-// Users may override this by passing an explicit media type by using
-// a combination of `dataurl.WithMediaType()` and `url.WithMediaTypeParams()` options.
-//
-// Also by default the data is encoded using base64 encoding when
-// the media type is anything other than a `text/****` type.
-//
-// You may override this by using the `dataurl.WithBase64Encoding()` option.
-func Encode(data []byte, options ...EncodeOption) ([]byte, error) {
-	var dst bytes.Buffer
-	var mt string
-	var params map[string]string
-	var explicitBase64 bool // true if the user specified base64
-	var encodeBase64 bool
-	for _, option := range options {
-		switch option.Ident() {
-		case identMediaType{}:
-			mt = option.Value().(string)
-		case identMediaTypeParams{}:
-			params = option.Value().(map[string]string)
-		case identBase64Encoding{}:
-			explicitBase64 = true
-			encodeBase64 = option.Value().(bool)
+// unescapeLenient decodes percent-escaped sequences in data. Invalid
+// sequences are copied verbatim, and never result in an error.
+func unescapeLenient(data []byte) []byte {
+	return appendUnescapedLenient(make([]byte, 0, len(data)), data)
+}
+
+// appendUnescapedLenient is like unescapeLenient, but appends the result to dst
+func appendUnescapedLenient(dst, data []byte) []byte {
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if c == '%' && i+2 < len(data) && isHex(data[i+1]) && isHex(data[i+2]) {
+			c = unhex(data[i+1])<<4 | unhex(data[i+2])
+			i += 2
 		}
+		dst = append(dst, c)
 	}
+	return dst
+}
 
-	if mt == "" {
-		mt = http.DetectContentType(data)
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
 	}
+}
 
-	if strings.IndexByte(mt, ';') > -1 {
-		if len(params) != 0 {
-			// the user specified a media type with parameters, _AND_
-			// gave us more parameters to work with
-			parsedMt, parsedParams, err := mime.ParseMediaType(mt)
-			if err != nil {
-				return nil, fmt.Errorf(`failed to parse media type: %w`, err)
-			}
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
 
-			// merge parsedParams and params. params takes precedence,
-			// so overwrite it
-			for k, v := range params {
-				parsedParams[k] = v
-			}
-			mt = mime.FormatMediaType(parsedMt, parsedParams)
-		}
-	} else if len(params) != 0 {
-		// mt is something like 'text/plain', and we have extra parameters
-		mt = mime.FormatMediaType(mt, params)
-	}
+// trimSpace removes leading and trailing ASCII whitespace
+func trimSpace(data []byte) []byte {
+	return trimRightSpace(trimLeftSpace(data))
+}
 
-	// It is possible that either the user or the library that we depend
-	// on provides us with a media type that is tiny bit off from what
-	// we want...
-	//
-	// namely: https://cs.opensource.google/go/go/+/refs/tags/go1.18.4:src/net/http/sniff.go;l=308
-	//
-	// Here, DetectContentType may return a media type with a space after the semicolon,
-	// which is not good for our case. Forcefully fix it
-	mt = strings.Replace(mt, `; `, `;`, -1)
+func trimLeftSpace(data []byte) []byte {
+	for len(data) > 0 && isSpace(data[0]) {
+		data = data[1:]
+	}
+	return data
+}
 
-	dst.Write(scheme)
-	dst.WriteString(mt)
+func trimRightSpace(data []byte) []byte {
+	for len(data) > 0 && isSpace(data[len(data)-1]) {
+		data = data[:len(data)-1]
+	}
+	return data
+}
 
-	if !explicitBase64 {
-		// The user has not explicitly provided us with the option to
-		// either use or not use base64. We're going to use base64
-		// if and only if the data is not a text-type
-		if !strings.HasPrefix(mt, `text`) {
-			// use base64
-			encodeBase64 = true
+// isToken returns true if data is a non-empty RFC 2045 token
+func isToken(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	for _, c := range data {
+		if !isTokenChar(c) {
+			return false
 		}
 	}
+	return true
+}
 
-	if encodeBase64 {
-		dst.Write(base64Marker)
-	}
+func isTokenChar(c byte) bool {
+	return c > 0x20 && c < 0x7f && strings.IndexByte(`()<>@,;:\"/[]?=`, c) < 0
+}
 
-	dst.WriteByte(',')
+// unquote decodes an RFC 2045 quoted-string
+func unquote(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != '"' || data[len(data)-1] != '"' {
+		return nil, fmt.Errorf(`invalid quoted string %q`, data)
+	}
+	data = data[1 : len(data)-1]
 
-	if encodeBase64 {
-		enc := base64.NewEncoder(b64enc, &dst)
-		_, _ = enc.Write(data)
-		enc.Close()
-	} else {
-		writeEscapedSequence(&dst, data)
+	dst := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '"', '\r':
+			return nil, fmt.Errorf(`unexpected character %q in quoted string`, c)
+		case '\\':
+			if i+1 >= len(data) {
+				return nil, fmt.Errorf(`unexpected end of quoted string`)
+			}
+			i++
+			dst = append(dst, data[i])
+		default:
+			dst = append(dst, c)
+		}
 	}
+	return dst, nil
+}
 
-	return dst.Bytes(), nil
+// Encode encodes a piece of data into data URL format.
+//
+// By default this function auto-detects the content of the given piece of
+// data using `"net/http".DetectContentType`.
+//
+// Users may override this by passing an explicit media type by using
+// a combination of `dataurl.WithMediaType()` and `url.WithMediaTypeParams()` options.
+//
+// Also by default the data is encoded using base64 encoding when
+// the media type is anything other than a `text/****` type.
+//
+// You may override this by using the `dataurl.WithBase64Encoding()` option.
+// The base64 payload uses the standard, padded alphabet unless another
+// variant is specified using the `dataurl.WithBase64Variant()` option.
+func Encode(data []byte, options ...EncodeOption) ([]byte, error) {
+	// room for the header and the payload in the most common case,
+	// which is a base64 encoded payload
+	dst := make([]byte, 0, 64+base64.StdEncoding.EncodedLen(len(data)))
+	encoded, err := AppendEncode(dst, data, options...)
+	if err != nil {
+		return nil, err
+	}
+	return encoded, nil
 }
 
 func isNotReserved(b byte) bool {
@@ -290,13 +814,21 @@ func isNotReserved(b byte) bool {
 		b == '~'
 }
 
-func writeEscapedSequence(dst *bytes.Buffer, data []byte) {
+// isURLChar returns true if b is a "uric" character as specified in
+// RFC 2396, excluding the '%' character used for escaped sequences
+func isURLChar(b byte) bool {
+	return isNotReserved(b) || strings.IndexByte(`;/?:@&=+$,`, b) > -1
+}
+
+// appendEscaped percent-escapes the bytes in data that are not safe in
+// profile, and appends the result to dst
+func appendEscaped(dst, data []byte, profile EscapeProfile) []byte {
 	for _, b := range data {
-		if isNotReserved(b) {
-			dst.WriteByte(b)
+		if profile.isSafe(b) {
+			dst = append(dst, b)
 			continue
 		}
-		fmt.Fprintf(dst, `%%%02X`, b)
-
+		dst = append(dst, '%', upperHex[b>>4], upperHex[b&0xf])
 	}
+	return dst
 }
@@ -1,19 +1,72 @@
 package dataurl_test
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"html"
+	"io"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/lestrrat-go/dataurl"
 	"github.com/stretchr/testify/require"
 )
 
+// params creates media type parameters from a list of alternating
+// names and values
+func params(kv ...string) map[string]string {
+	p := make(map[string]string, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		p[kv[i]] = kv[i+1]
+	}
+	return p
+}
+
+// requireSameAsParse checks that AppendDecode decodes src in the same
+// way as Parse, or fails with the same kind of error at the same offset
+func requireSameAsParse(t *testing.T, src []byte, options ...dataurl.ParseOption) {
+	t.Helper()
+
+	u, perr := dataurl.Parse(src, options...)
+	_, data, err := dataurl.AppendDecode(nil, src, options...)
+	if perr == nil {
+		require.NoError(t, err, `dataurl.AppendDecode should succeed (input = %q)`, src)
+		require.Equal(t, string(u.Data), string(data), `data should match (input = %q)`, src)
+		return
+	}
+	require.Error(t, err, `dataurl.AppendDecode should fail (input = %q)`, src)
+
+	var expected, actual *dataurl.ParseError
+	require.True(t, errors.As(perr, &expected), `error should be a *dataurl.ParseError`)
+	require.True(t, errors.As(err, &actual), `error should be a *dataurl.ParseError`)
+	require.Equal(t, expected.Kind, actual.Kind, `error kinds should match (input = %q)`, src)
+	require.Equal(t, expected.Offset, actual.Offset, `error offsets should match (input = %q)`, src)
+}
+
+// orderedParams is like params, but keeps the parameters in order
+func orderedParams(kv ...string) dataurl.Params {
+	var p dataurl.Params
+	for i := 0; i < len(kv); i += 2 {
+		p.Set(kv[i], kv[i+1])
+	}
+	return p
+}
+
 func TestParse(t *testing.T) {
 	testcases := []struct {
 		Name     string
 		Data     []byte
 		Error    bool
 		Expected *dataurl.URL
+		// Order is the expected order of the parameters, if it is
+		// not the sorted order
+		Order []string
 	}{
 		{
 			Name: `sample from RFC`,
@@ -30,6 +83,7 @@ func TestParse(t *testing.T) {
 					}
 					return v
 				})(`R0lGODdhMAAwAPAAAAAAAP///ywAAAAAMAAwAAAC8IyPqcvt3wCcDkiLc7C0qwyGHhSWpjQu5yqmCYsapyuvUUlvONmOZtfzgFzByTB10QgxOR0TqBQejhRNzOfkVJ+5YiUqrXF5Y5lKh/DeuNcP5yLWGsEbtLiOSpa/TPg7JpJHxyendzWTBfX0cxOnKPjgBzi4diinWGdkF8kjdfnycQZXZeYGejmJlZeGl9i2icVqaNVailT6F5iJ90m6mvuTS4OK05M0vDk0Q4XUtwvKOzrcd3iq9uisF81M1OIcR7lEewwcLp7tuNNkM3uNna3F2JQFo97Vriy/Xl4/f1cf5VWzXyym7PHhhx4dbgYKAAA7`),
+				Base64: true,
 			},
 		},
 		{
@@ -55,7 +109,8 @@ func TestParse(t *testing.T) {
 						`charset`: `US-ASCII`,
 					},
 				},
-				Data: []byte(`hello, world!`),
+				Data:   []byte(`hello, world!`),
+				Base64: true,
 			},
 		},
 		{
@@ -70,13 +125,22 @@ func TestParse(t *testing.T) {
 						`odd param2`: `hello world`,
 					},
 				},
-				Data: []byte(`{"hello":"world"}`),
+				Data:   []byte(`{"hello":"world"}`),
+				Base64: true,
 			},
+			Order: []string{`charset`, `oddparam1`, `odd param2`},
+		},
+		{
+			Name:  `parameters that are the same once unescaped`,
+			Data:  []byte(`data:text/plain;a%2Db=1;a-b=2,hello`),
+			Error: true,
 		},
 	}
 	for _, tc := range testcases {
 		tc := tc
 		t.Run(tc.Name, func(t *testing.T) {
+			requireSameAsParse(t, tc.Data)
+
 			u, err := dataurl.Parse(tc.Data)
 			if tc.Error {
 				require.Error(t, err, `dataurl.Parse should fail`)
@@ -84,59 +148,2281 @@ func TestParse(t *testing.T) {
 			}
 
 			require.NoError(t, err, `dataurl.Parse should succeed`)
-			require.Equal(t, tc.Expected, u)
+
+			// the order of the parameters is compared separately
+			actual := *u
+			actual.MediaType = dataurl.MediaType{Type: u.MediaType.Type, Params: u.MediaType.Params}
+			require.Equal(t, tc.Expected, &actual)
+			if tc.Order != nil {
+				require.Equal(t, tc.Order, u.MediaType.OrderedParams().Names(), `parameters should be in the original order`)
+			}
 		})
 	}
 }
 
-func TestEncode(t *testing.T) {
+func TestParseStrict(t *testing.T) {
 	testcases := []struct {
-		Data     []byte
-		Expected []byte
-		Options  []dataurl.EncodeOption
-		Error    bool
+		Name          string
+		Data          []byte
+		StrictError   bool
+		LenientError  bool
+		Expected      *dataurl.URL
+		LenientResult *dataurl.URL
 	}{
 		{
-			Data:     []byte(`hello, world!`),
-			Expected: []byte(`data:text/plain;charset=utf-8,hello%2C%20world!`),
+			Name: `well-formed`,
+			Data: []byte(`data:text/plain;charset=utf-8,hello%2C%20world!`),
+			Expected: &dataurl.URL{
+				MediaType: dataurl.MediaType{
+					Type:   `text/plain`,
+					Params: params(`charset`, `utf-8`),
+				},
+				Data: []byte(`hello, world!`),
+			},
 		},
 		{
-			Data: []byte(`{"hello":"world"}`),
-			Options: []dataurl.EncodeOption{
-				dataurl.WithMediaType(`application/json`),
-				dataurl.WithMediaTypeParams(map[string]string{
-					`charset`: `utf-8`,
-				}),
+			Name: `reserved characters in data`,
+			Data: []byte(`data:,a/b?c=d;e`),
+			Expected: &dataurl.URL{
+				MediaType: dataurl.MediaType{
+					Type:   `text/plain`,
+					Params: params(`charset`, `US-ASCII`),
+				},
+				Data: []byte(`a/b?c=d;e`),
 			},
-			Expected: []byte(`data:application/json;charset=utf-8;base64,eyJoZWxsbyI6IndvcmxkIn0=`),
 		},
 		{
-			Data: []byte(`{"hello":"world"}`),
-			Options: []dataurl.EncodeOption{
-				dataurl.WithMediaType(`application/json; charset=US-ASCII`),
-				dataurl.WithMediaTypeParams(map[string]string{
-					`charset`: `utf-8`,
-				}),
+			Name: `charset only`,
+			Data: []byte(`data:;charset=utf-8,hello`),
+			Expected: &dataurl.URL{
+				MediaType: dataurl.MediaType{
+					Type:   `text/plain`,
+					Params: params(`charset`, `utf-8`),
+				},
+				Data: []byte(`hello`),
 			},
-			Expected: []byte(`data:application/json;charset=utf-8;base64,eyJoZWxsbyI6IndvcmxkIn0=`),
 		},
+		{
+			Name:        `unescaped space in data`,
+			Data:        []byte(`data:,hello world`),
+			StrictError: true,
+			LenientResult: &dataurl.URL{
+				MediaType: dataurl.MediaType{
+					Type:   `text/plain`,
+					Params: params(`charset`, `US-ASCII`),
+				},
+				Data: []byte(`hello world`),
+			},
+		},
+		{
+			Name:        `surrounding whitespace and upper-case marker`,
+			Data:        []byte(" data:text/plain; BASE64 ,aGVsbG8=\n"),
+			StrictError: true,
+			LenientResult: &dataurl.URL{
+				MediaType: dataurl.MediaType{
+					Type:   `text/plain`,
+					Params: map[string]string{},
+				},
+				Data:   []byte(`hello`),
+				Base64: true,
+			},
+		},
+		{
+			Name:        `invalid media type`,
+			Data:        []byte(`data:text/(plain),hello`),
+			StrictError: true,
+			LenientResult: &dataurl.URL{
+				MediaType: dataurl.MediaType{
+					Type:   `text/plain`,
+					Params: params(`charset`, `US-ASCII`),
+				},
+				Data: []byte(`hello`),
+			},
+		},
+		{
+			Name:        `invalid escape sequence`,
+			Data:        []byte(`data:,100%zz`),
+			StrictError: true,
+			LenientResult: &dataurl.URL{
+				MediaType: dataurl.MediaType{
+					Type:   `text/plain`,
+					Params: params(`charset`, `US-ASCII`),
+				},
+				Data: []byte(`100%zz`),
+			},
+		},
+		{
+			Name: `parameters that are the same once unescaped`,
+			Data: []byte(`data:text/plain;a%2Db=1;a-b=2,hello`),
+			Expected: &dataurl.URL{
+				MediaType: dataurl.MediaType{
+					Type:   `text/plain`,
+					Params: params(`a-b`, `2`),
+				},
+				Data: []byte(`hello`),
+			},
+			LenientResult: &dataurl.URL{
+				MediaType: dataurl.MediaType{
+					Type:   `text/plain`,
+					Params: params(`charset`, `US-ASCII`),
+				},
+				Data: []byte(`hello`),
+			},
+		},
+		{
+			Name:         `no comma`,
+			Data:         []byte(`data:text/plain`),
+			StrictError:  true,
+			LenientError: true,
+		},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Run(`strict`, func(t *testing.T) {
+				requireSameAsParse(t, tc.Data, dataurl.WithStrict(true))
+
+				u, err := dataurl.Parse(tc.Data, dataurl.WithStrict(true))
+				if tc.StrictError {
+					require.Error(t, err, `dataurl.Parse should fail`)
+					return
+				}
+				require.NoError(t, err, `dataurl.Parse should succeed`)
+				require.Equal(t, tc.Expected, u, `results should match`)
+			})
+			t.Run(`lenient`, func(t *testing.T) {
+				requireSameAsParse(t, tc.Data, dataurl.WithStrict(false))
+
+				u, err := dataurl.Parse(tc.Data, dataurl.WithStrict(false))
+				if tc.LenientError {
+					require.Error(t, err, `dataurl.Parse should fail`)
+					return
+				}
+				require.NoError(t, err, `dataurl.Parse should succeed`)
+
+				expected := tc.LenientResult
+				if expected == nil {
+					expected = tc.Expected
+				}
+				require.Equal(t, expected, u, `results should match`)
+			})
+		})
 	}
+}
 
+func TestParseWHATWG(t *testing.T) {
+	textPlain := dataurl.MediaType{
+		Type:   `text/plain`,
+		Params: params(`charset`, `US-ASCII`),
+	}
+	testcases := []struct {
+		Name     string
+		Data     string
+		Error    bool
+		Expected *dataurl.URL
+	}{
+		{
+			Name:     `invalid media type falls back to the default`,
+			Data:     `data:text/(plain),hello`,
+			Expected: &dataurl.URL{MediaType: textPlain, Data: []byte(`hello`)},
+		},
+		{
+			Name: `base64 marker with spaces`,
+			Data: "\t data:text/html;  BaSe64,PGI+aGk8L2I+ ",
+			Expected: &dataurl.URL{
+				MediaType: dataurl.MediaType{Type: `text/html`, Params: map[string]string{}},
+				Data:      []byte(`<b>hi</b>`),
+				Base64:    true,
+			},
+		},
+		{
+			Name:     `percent-decoded before base64`,
+			Data:     `data:;base64,aGVsbG8%3D`,
+			Expected: &dataurl.URL{MediaType: textPlain, Data: []byte(`hello`), Base64: true},
+		},
+		{
+			Name:     `forgiving base64`,
+			Data:     "data:;base64,aGVs\nbG8",
+			Expected: &dataurl.URL{MediaType: textPlain, Data: []byte(`hello`), Base64: true},
+		},
+		{
+			Name:  `invalid base64`,
+			Data:  `data:;base64,aGVsbG8=x`,
+			Error: true,
+		},
+		{
+			Name:     `fragment is ignored`,
+			Data:     `data:,hello#world`,
+			Expected: &dataurl.URL{MediaType: textPlain, Data: []byte(`hello`)},
+		},
+		{
+			Name: `parameters`,
+			Data: `data:Text/HTML;Charset="utf\-8";charset=ascii;foo;bar=,x`,
+			Expected: &dataurl.URL{
+				MediaType: dataurl.MediaType{
+					Type:   `text/html`,
+					Params: params(`charset`, `utf-8`),
+				},
+				Data: []byte(`x`),
+			},
+		},
+		{
+			Name:  `no comma`,
+			Data:  `data:text/plain`,
+			Error: true,
+		},
+	}
 	for _, tc := range testcases {
 		tc := tc
-		t.Run(string(tc.Data), func(t *testing.T) {
-			u, err := dataurl.Encode(tc.Data, tc.Options...)
+		t.Run(tc.Name, func(t *testing.T) {
+			requireSameAsParse(t, []byte(tc.Data), dataurl.WithParseMode(dataurl.ParseModeWHATWG))
+
+			u, err := dataurl.Parse([]byte(tc.Data), dataurl.WithParseMode(dataurl.ParseModeWHATWG))
 			if tc.Error {
-				require.NoError(t, err, `dataurl.Encode should fail`)
+				require.Error(t, err, `dataurl.Parse should fail`)
 				return
 			}
-			require.NoError(t, err, `dataurl.Encode should succeed`)
+			require.NoError(t, err, `dataurl.Parse should succeed`)
 			require.Equal(t, tc.Expected, u, `results should match`)
+		})
+	}
+}
 
-			parsed, err := dataurl.Parse(u)
-			require.NoError(t, err, `dataurl.Parse should succeed`)
-			require.Equal(t, tc.Data, parsed.Data, `data should match`)
+func TestBase64Marker(t *testing.T) {
+	allModes := []dataurl.ParseMode{dataurl.ParseModeDefault, dataurl.ParseModeStrict, dataurl.ParseModeLenient}
+	testcases := []struct {
+		Name     string
+		Data     string
+		Modes    []dataurl.ParseMode
+		Error    bool
+		Params   map[string]string
+		Expected string
+	}{
+		{
+			Name:     `marker as prefix of a parameter name`,
+			Data:     `data:text/plain;foo=x;base64abc=1,hello`,
+			Modes:    allModes,
+			Params:   map[string]string{`foo`: `x`, `base64abc`: `1`},
+			Expected: `hello`,
+		},
+		{
+			Name:     `marker as prefix of a parameter name, followed by other parameters`,
+			Data:     `data:text/plain;base64abc=1;foo=x,hello`,
+			Modes:    allModes,
+			Params:   map[string]string{`foo`: `x`, `base64abc`: `1`},
+			Expected: `hello`,
+		},
+		{
+			Name:     `marker as suffix of a parameter name`,
+			Data:     `data:text/plain;xbase64=1,aGVsbG8`,
+			Modes:    allModes,
+			Params:   map[string]string{`xbase64`: `1`},
+			Expected: `aGVsbG8`,
+		},
+		{
+			Name:     `marker as a parameter name`,
+			Data:     `data:text/plain;base64=1,aGVsbG8`,
+			Modes:    allModes,
+			Params:   map[string]string{`base64`: `1`},
+			Expected: `aGVsbG8`,
+		},
+		{
+			Name:     `marker inside a quoted value`,
+			Data:     `data:text/plain;a=";base64",hello`,
+			Modes:    []dataurl.ParseMode{dataurl.ParseModeDefault, dataurl.ParseModeLenient},
+			Params:   map[string]string{`a`: `;base64`},
+			Expected: `hello`,
+		},
+		{
+			Name:     `marker inside a quoted value, followed by a real marker`,
+			Data:     `data:text/plain;a="x;base64";base64,aGVsbG8=`,
+			Modes:    []dataurl.ParseMode{dataurl.ParseModeDefault, dataurl.ParseModeLenient},
+			Params:   map[string]string{`a`: `x;base64`},
+			Expected: `hello`,
+		},
+		{
+			Name:     `escaped marker inside the payload`,
+			Data:     `data:,hello%3Bbase64%2Cworld`,
+			Modes:    allModes,
+			Params:   map[string]string{`charset`: `US-ASCII`},
+			Expected: `hello;base64,world`,
+		},
+		{
+			Name:     `marker inside the payload`,
+			Data:     `data:text/plain,abc;base64,aGVsbG8=`,
+			Modes:    []dataurl.ParseMode{dataurl.ParseModeStrict, dataurl.ParseModeLenient},
+			Params:   map[string]string{},
+			Expected: `abc;base64,aGVsbG8=`,
+		},
+		{
+			Name:     `real marker`,
+			Data:     `data:text/plain;charset=utf-8;base64,aGVsbG8=`,
+			Modes:    allModes,
+			Params:   map[string]string{`charset`: `utf-8`},
+			Expected: `hello`,
+		},
+		{
+			Name:  `marker that is not the last parameter`,
+			Data:  `data:text/plain;base64;charset=utf-8,aGVsbG8=`,
+			Modes: []dataurl.ParseMode{dataurl.ParseModeDefault, dataurl.ParseModeStrict},
+			Error: true,
+		},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			for _, mode := range tc.Modes {
+				requireSameAsParse(t, []byte(tc.Data), dataurl.WithParseMode(mode))
+
+				u, err := dataurl.Parse([]byte(tc.Data), dataurl.WithParseMode(mode))
+				if tc.Error {
+					require.Error(t, err, `dataurl.Parse should fail (mode = %d)`, mode)
+					continue
+				}
+				require.NoError(t, err, `dataurl.Parse should succeed (mode = %d)`, mode)
+				require.Equal(t, tc.Params, u.MediaType.Params, `parameters should match (mode = %d)`, mode)
+				require.Equal(t, tc.Expected, string(u.Data), `data should match (mode = %d)`, mode)
+			}
+		})
+	}
+}
+
+func TestParams(t *testing.T) {
+	t.Run(`Get, Set and Del`, func(t *testing.T) {
+		var p dataurl.Params
+		require.Equal(t, 0, p.Len(), `zero value should be empty`)
+
+		p.Set(`Charset`, `utf-8`)
+		p.Set(`foo`, `bar`)
+		p.Set(`CHARSET`, `us-ascii`)
+		require.Equal(t, `us-ascii`, p.Get(`charset`), `names should be case-insensitive`)
+		require.Equal(t, []string{`charset`, `foo`}, p.Names(), `replacing a value should keep its position`)
+
+		_, ok := p.Lookup(`missing`)
+		require.False(t, ok, `p.Lookup should fail for missing parameters`)
+		require.Equal(t, ``, p.Get(`missing`), `p.Get should return an empty string for missing parameters`)
+
+		p.Del(`Charset`)
+		require.Equal(t, []string{`foo`}, p.Names(), `parameter should be deleted`)
+		require.Equal(t, map[string]string{`foo`: `bar`}, p.Map(), `map view should match`)
+	})
+	t.Run(`NewParams`, func(t *testing.T) {
+		p := dataurl.NewParams(map[string]string{`b`: `2`, `A`: `1`})
+		require.Equal(t, []string{`a`, `b`}, p.Names(), `parameters should be sorted`)
+		require.Equal(t, `1`, p.Get(`a`), `values should match`)
+	})
+	t.Run(`OrderedParams`, func(t *testing.T) {
+		mt := dataurl.MediaType{Type: `text/plain`, Params: params(`charset`, `utf-8`, `b`, `2`)}
+		p := mt.OrderedParams()
+		require.Equal(t, []string{`b`, `charset`}, p.Names(), `parameters should be sorted`)
+		require.Equal(t, `utf-8`, p.Get(`CHARSET`), `names should be case-insensitive`)
+	})
+	t.Run(`SetOrderedParams`, func(t *testing.T) {
+		u, err := dataurl.Parse([]byte(`data:text/plain;z=1;charset=utf-8;a=2,hello`))
+		require.NoError(t, err, `dataurl.Parse should succeed`)
+
+		p := u.MediaType.OrderedParams()
+		p.Del(`charset`)
+		p.Set(`b`, `3`)
+		p.Set(`Z`, `4`)
+		u.MediaType.SetOrderedParams(p)
+		require.Equal(t, map[string]string{`z`: `4`, `a`: `2`, `b`: `3`}, u.MediaType.Params, `parameters should be stored`)
+		require.Equal(t, []string{`z`, `a`, `b`}, u.MediaType.OrderedParams().Names(), `parameter order should be stored`)
+
+		text, err := u.MarshalText()
+		require.NoError(t, err, `u.MarshalText should succeed`)
+		require.Equal(t, `data:text/plain;z=4;a=2;b=3,hello`, string(text), `parameters should be written in order`)
+	})
+	t.Run(`parsed parameters keep their order`, func(t *testing.T) {
+		const data = `data:text/plain;z=1;Odd%2DParam=2;A=3,hello`
+		for _, mode := range []dataurl.ParseMode{dataurl.ParseModeDefault, dataurl.ParseModeStrict, dataurl.ParseModeLenient} {
+			u, err := dataurl.Parse([]byte(data), dataurl.WithParseMode(mode), dataurl.WithPreserveInput(true))
+			require.NoError(t, err, `dataurl.Parse should succeed (mode = %d)`, mode)
+			require.Equal(t, []string{`z`, `odd-param`, `a`}, u.MediaType.OrderedParams().Names(), `parameter order should match (mode = %d)`, mode)
+			require.Equal(t, `2`, u.MediaType.OrderedParams().Get(`Odd-Param`), `parameter should be found (mode = %d)`, mode)
+		}
+
+		u, err := dataurl.Parse([]byte(`data:text/plain;z=1;b=2;A=3,hello`), dataurl.WithParseMode(dataurl.ParseModeWHATWG), dataurl.WithPreserveInput(true))
+		require.NoError(t, err, `dataurl.Parse should succeed`)
+		require.Equal(t, []string{`z`, `b`, `a`}, u.MediaType.OrderedParams().Names(), `parameter order should match`)
+	})
+}
+
+func TestMediaType(t *testing.T) {
+	t.Run(`ParseMediaType`, func(t *testing.T) {
+		testcases := []struct {
+			Input    string
+			Error    bool
+			Expected dataurl.MediaType
+			String   string
+		}{
+			{
+				Input:    `text/plain`,
+				Expected: dataurl.MediaType{Type: `text/plain`, Params: map[string]string{}},
+				String:   `text/plain`,
+			},
+			{
+				Input:    ` Text/Plain ; Charset=UTF-8 ;format=flowed `,
+				Expected: dataurl.MediaType{Type: `text/plain`, Params: params(`charset`, `UTF-8`, `format`, `flowed`)},
+				String:   `text/plain;charset=UTF-8;format=flowed`,
+			},
+			{
+				Input:    `application/vnd.api+json;z=1;a="x;\"y\""`,
+				Expected: dataurl.MediaType{Type: `application/vnd.api+json`, Params: params(`z`, `1`, `a`, `x;"y"`)},
+				String:   `application/vnd.api+json;z=1;a="x;\"y\""`,
+			},
+			{
+				Input:    `text/plain;title*=utf-8''%E2%82%AC%20rates`,
+				Expected: dataurl.MediaType{Type: `text/plain`, Params: params(`title`, "\u20ac rates")},
+				String:   `text/plain;title*=utf-8''%E2%82%AC%20rates`,
+			},
+			{
+				Input:    `text/plain;charset=utf-8;`,
+				Expected: dataurl.MediaType{Type: `text/plain`, Params: params(`charset`, `utf-8`)},
+				String:   `text/plain;charset=utf-8`,
+			},
+			{Input: ``, Error: true},
+			{Input: `text`, Error: true},
+			{Input: `text/`, Error: true},
+			{Input: `/plain`, Error: true},
+			{Input: `-text/plain`, Error: true},
+			{Input: `text/pl*in`, Error: true},
+			{Input: `text/plain;charset`, Error: true},
+			{Input: `text/plain;charset = utf-8`, Error: true},
+			{Input: `text/plain;a=1;A=2`, Error: true},
+			{Input: `text/plain;a="unterminated`, Error: true},
+			{Input: `text/plain;a=x y`, Error: true},
+			{Input: `text/plain;a*=iso-2022-jp''x`, Error: true},
+		}
+		for _, tc := range testcases {
+			tc := tc
+			t.Run(tc.Input, func(t *testing.T) {
+				mt, err := dataurl.ParseMediaType(tc.Input)
+				if tc.Error {
+					require.Error(t, err, `dataurl.ParseMediaType should fail`)
+					require.True(t, errors.Is(err, dataurl.ErrInvalidMediaType), `error should match dataurl.ErrInvalidMediaType`)
+					return
+				}
+				require.NoError(t, err, `dataurl.ParseMediaType should succeed`)
+				require.Equal(t, tc.Expected.Type, mt.Type, `types should match`)
+				require.Equal(t, tc.Expected.Params, mt.Params, `parameters should match`)
+				require.Equal(t, tc.String, mt.String(), `mt.String should match`)
+
+				reparsed, err := dataurl.ParseMediaType(mt.String())
+				require.NoError(t, err, `dataurl.ParseMediaType should succeed on the output of mt.String`)
+				require.Equal(t, mt, reparsed, `media types should survive a round trip`)
+			})
+		}
+	})
+	t.Run(`String with invalid values`, func(t *testing.T) {
+		require.Equal(t, ``, dataurl.MediaType{Type: `text`}.String(), `missing subtype should be rejected`)
+		require.Equal(t, ``, dataurl.MediaType{Type: `text/plain`, Params: params(`a b`, `c`)}.String(), `invalid parameter names should be rejected`)
+	})
+	t.Run(`accessors`, func(t *testing.T) {
+		testcases := []struct {
+			Type    string
+			Top     string
+			Sub     string
+			Suffix  string
+			Essence string
+		}{
+			{Type: `Image/SVG+XML`, Top: `image`, Sub: `svg+xml`, Suffix: `+xml`, Essence: `image/svg+xml`},
+			{Type: `application/vnd.a+b+json`, Top: `application`, Sub: `vnd.a+b+json`, Suffix: `+json`, Essence: `application/vnd.a+b+json`},
+			{Type: `text/plain`, Top: `text`, Sub: `plain`, Essence: `text/plain`},
+			{Type: `text`, Top: `text`, Essence: `text`},
+		}
+		for _, tc := range testcases {
+			mt := dataurl.MediaType{Type: tc.Type}
+			require.Equal(t, tc.Top, mt.Top(), `mt.Top should match (%s)`, tc.Type)
+			require.Equal(t, tc.Sub, mt.Sub(), `mt.Sub should match (%s)`, tc.Type)
+			require.Equal(t, tc.Suffix, mt.Suffix(), `mt.Suffix should match (%s)`, tc.Type)
+			require.Equal(t, tc.Essence, mt.Essence(), `mt.Essence should match (%s)`, tc.Type)
+		}
+	})
+}
+
+func TestMatch(t *testing.T) {
+	testcases := []struct {
+		MediaType string
+		Pattern   string
+		Expected  bool
+	}{
+		{MediaType: `image/png`, Pattern: `*/*`, Expected: true},
+		{MediaType: `image/png`, Pattern: `*`, Expected: true},
+		{MediaType: `image/png`, Pattern: `image/*`, Expected: true},
+		{MediaType: `image/png`, Pattern: `IMAGE/PNG`, Expected: true},
+		{MediaType: `image/png`, Pattern: `image/jpeg`, Expected: false},
+		{MediaType: `image/png`, Pattern: `text/*`, Expected: false},
+		{MediaType: `application/ld+json`, Pattern: `application/*+json`, Expected: true},
+		{MediaType: `application/ld+json`, Pattern: `*/*+JSON`, Expected: true},
+		{MediaType: `application/json`, Pattern: `application/*+json`, Expected: false},
+		{MediaType: `image/svg+xml`, Pattern: `application/*+xml`, Expected: false},
+		{MediaType: `text/html;charset=UTF-8;level=1`, Pattern: `text/*;charset=utf-8`, Expected: true},
+		{MediaType: `text/html;charset=UTF-8;level=1`, Pattern: `text/*; level=1; charset="utf-8"`, Expected: true},
+		{MediaType: `text/html;level=1`, Pattern: `text/*;level=2`, Expected: false},
+		{MediaType: `text/html`, Pattern: `text/*;charset=utf-8`, Expected: false},
+		{MediaType: `text/plain`, Pattern: `*/plain`, Expected: false},
+		{MediaType: `text/plain`, Pattern: `text`, Expected: false},
+		{MediaType: `text/plain`, Pattern: `text/*;charset`, Expected: false},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.MediaType+` `+tc.Pattern, func(t *testing.T) {
+			mt, err := dataurl.ParseMediaType(tc.MediaType)
+			require.NoError(t, err, `dataurl.ParseMediaType should succeed`)
+			require.Equal(t, tc.Expected, mt.Match(tc.Pattern), `mt.Match should return %t`, tc.Expected)
+		})
+	}
+
+	t.Run(`IsA`, func(t *testing.T) {
+		u, err := dataurl.Parse([]byte(`data:image/svg+xml;charset=utf-8,%3Csvg%2F%3E`))
+		require.NoError(t, err, `dataurl.Parse should succeed`)
+		require.True(t, u.IsA(`image/*`), `u.IsA should match a wildcard subtype`)
+		require.True(t, u.IsA(`image/png`, `*/*+xml`), `u.IsA should match any of the patterns`)
+		require.False(t, u.IsA(`image/png`, `text/*`), `u.IsA should fail when no pattern matches`)
+		require.False(t, u.IsA(), `u.IsA should fail without patterns`)
+	})
+}
+
+func TestPolicy(t *testing.T) {
+	policy := &dataurl.Policy{
+		Allow: []string{`image/*`, `text/*`, `application/*+json`},
+		Deny:  []string{`text/html`, `image/svg+xml`, `application/javascript`},
+	}
+	testcases := []struct {
+		Name  string
+		Data  string
+		Rule  string
+		Error bool
+	}{
+		{Name: `allowed image`, Data: `data:image/png;base64,iVBORw0KGgo=`},
+		{Name: `allowed text`, Data: `data:,hello`},
+		{Name: `allowed suffix`, Data: `data:application/ld+json,%7B%7D`},
+		{Name: `denied HTML`, Data: `data:text/html,%3Cscript%3E`, Rule: `text/html`, Error: true},
+		{Name: `denied HTML with parameters`, Data: `data:TEXT/HTML;charset=utf-8,%3Cscript%3E`, Rule: `text/html`, Error: true},
+		{Name: `denied SVG`, Data: `data:image/svg+xml;base64,PHN2Zy8+`, Rule: `image/svg+xml`, Error: true},
+		{Name: `denied JavaScript`, Data: `data:application/javascript,alert(1)`, Rule: `application/javascript`, Error: true},
+		{Name: `not in the allowlist`, Data: `data:application/octet-stream;base64,AAAA`, Error: true},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			modes := []dataurl.ParseMode{dataurl.ParseModeDefault, dataurl.ParseModeStrict, dataurl.ParseModeLenient, dataurl.ParseModeWHATWG}
+			for _, mode := range modes {
+				options := []dataurl.ParseOption{dataurl.WithParseMode(mode), dataurl.WithPolicy(policy)}
+
+				_, parseErr := dataurl.Parse([]byte(tc.Data), options...)
+				_, decodeErr := io.ReadAll(dataurl.NewDecoder(strings.NewReader(tc.Data), options...))
+				_, _, appendErr := dataurl.AppendDecode(nil, []byte(tc.Data), options...)
+
+				for _, err := range []error{parseErr, decodeErr, appendErr} {
+					if !tc.Error {
+						require.NoError(t, err, `parsing should succeed (mode = %d)`, mode)
+						continue
+					}
+					require.Error(t, err, `parsing should fail (mode = %d)`, mode)
+					require.True(t, errors.Is(err, dataurl.ErrPolicyViolation), `error should match dataurl.ErrPolicyViolation (mode = %d)`, mode)
+
+					var pe *dataurl.ParseError
+					require.True(t, errors.As(err, &pe), `error should be a *dataurl.ParseError (mode = %d)`, mode)
+					require.Equal(t, dataurl.KindPolicyViolation, pe.Kind, `error kinds should match (mode = %d)`, mode)
+					require.Equal(t, len(`data:`), pe.Offset, `offsets should match (mode = %d)`, mode)
+
+					var violation *dataurl.PolicyViolation
+					require.True(t, errors.As(err, &violation), `error should wrap a *dataurl.PolicyViolation (mode = %d)`, mode)
+					require.Equal(t, tc.Rule, violation.Rule, `rules should match (mode = %d)`, mode)
+				}
+			}
+		})
+	}
+
+	t.Run(`nil policy`, func(t *testing.T) {
+		var policy *dataurl.Policy
+		require.NoError(t, policy.Check(dataurl.MediaType{Type: `text/html`}), `nil policy should accept any media type`)
+	})
+	t.Run(`unparsable media types are checked as web browsers see them`, func(t *testing.T) {
+		// the lenient mode falls back to text/plain, while web browsers
+		// see text/html
+		const data = `data:text/html;x,<b>`
+		for _, mode := range []dataurl.ParseMode{dataurl.ParseModeLenient, dataurl.ParseModeWHATWG} {
+			options := []dataurl.ParseOption{dataurl.WithParseMode(mode), dataurl.WithPolicy(&dataurl.Policy{Deny: []string{`text/html`}})}
+
+			_, parseErr := dataurl.Parse([]byte(data), options...)
+			_, decodeErr := io.ReadAll(dataurl.NewDecoder(strings.NewReader(data), options...))
+			_, _, appendErr := dataurl.AppendDecode(nil, []byte(data), options...)
+			for _, err := range []error{parseErr, decodeErr, appendErr} {
+				require.True(t, errors.Is(err, dataurl.ErrPolicyViolation), `error should match dataurl.ErrPolicyViolation (mode = %d)`, mode)
+			}
+		}
+
+		u, err := dataurl.Parse([]byte(data), dataurl.WithStrict(false))
+		require.NoError(t, err, `dataurl.Parse should succeed without a policy`)
+		require.Equal(t, `text/plain`, u.MediaType.Type, `the lenient mode should fall back to the default media type`)
+	})
+	t.Run(`media type is checked before the payload is decoded`, func(t *testing.T) {
+		_, err := dataurl.Parse([]byte(`data:text/html;base64,!!!`), dataurl.WithPolicy(policy))
+		require.True(t, errors.Is(err, dataurl.ErrPolicyViolation), `error should match dataurl.ErrPolicyViolation`)
+
+		dec := dataurl.NewDecoder(io.MultiReader(strings.NewReader(`data:text/html,`), failingReader{}), dataurl.WithPolicy(policy))
+		_, err = dec.MediaType()
+		require.True(t, errors.Is(err, dataurl.ErrPolicyViolation), `error should match dataurl.ErrPolicyViolation`)
+	})
+}
+
+func TestLimits(t *testing.T) {
+	testcases := []struct {
+		Name    string
+		Data    string
+		Options []dataurl.ParseOption
+		Modes   []dataurl.ParseMode
+		Offset  int
+		Error   bool
+	}{
+		{
+			Name:    `encoded length within the limit`,
+			Data:    `data:,hello`,
+			Options: []dataurl.ParseOption{dataurl.WithMaxEncodedLength(11)},
+		},
+		{
+			Name:    `encoded length exceeds the limit`,
+			Data:    `data:,hello`,
+			Options: []dataurl.ParseOption{dataurl.WithMaxEncodedLength(10)},
+			Offset:  10,
+			Error:   true,
+		},
+		{
+			Name:    `decoded length within the limit`,
+			Data:    `data:;base64,aGVs bG8=`,
+			Options: []dataurl.ParseOption{dataurl.WithMaxDecodedLength(5), dataurl.WithIgnoreWhitespace(true)},
+		},
+		{
+			Name:    `decoded base64 length exceeds the limit`,
+			Data:    `data:;base64,aGVsbG8h`,
+			Options: []dataurl.ParseOption{dataurl.WithMaxDecodedLength(5)},
+			Offset:  13,
+			Error:   true,
+		},
+		{
+			Name:    `decoded escaped length within the limit`,
+			Data:    `data:,%68%65%6C%6C%6F`,
+			Options: []dataurl.ParseOption{dataurl.WithMaxDecodedLength(5)},
+		},
+		{
+			Name:    `decoded escaped length exceeds the limit`,
+			Data:    `data:,%68%65%6C%6C%6F!`,
+			Options: []dataurl.ParseOption{dataurl.WithMaxDecodedLength(5)},
+			Offset:  6,
+			Error:   true,
+		},
+		{
+			Name:    `header length within the limit`,
+			Data:    `data:text/plain;base64,aGVsbG8=`,
+			Options: []dataurl.ParseOption{dataurl.WithMaxHeaderLength(17)},
+		},
+		{
+			Name:    `header length exceeds the limit`,
+			Data:    `data:text/plain;base64,aGVsbG8=`,
+			Options: []dataurl.ParseOption{dataurl.WithMaxHeaderLength(16)},
+			Offset:  21,
+			Error:   true,
+		},
+		{
+			Name:    `header length is checked before normalization`,
+			Data:    "data:text/plain;a=\u00e9,X",
+			Options: []dataurl.ParseOption{dataurl.WithMaxHeaderLength(len("text/plain;a=\u00e9"))},
+			Modes:   []dataurl.ParseMode{dataurl.ParseModeWHATWG},
+		},
+		{
+			Name:    `parameter count within the limit`,
+			Data:    `data:text/plain;a=1;b=";";base64,aGVsbG8=`,
+			Options: []dataurl.ParseOption{dataurl.WithMaxParams(2)},
+		},
+		{
+			Name:    `parameter count exceeds the limit`,
+			Data:    `data:text/plain;a=1;b=2;c=3,hello`,
+			Options: []dataurl.ParseOption{dataurl.WithMaxParams(2)},
+			Offset:  5,
+			Error:   true,
+		},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			modes := tc.Modes
+			if modes == nil {
+				modes = []dataurl.ParseMode{dataurl.ParseModeDefault, dataurl.ParseModeWHATWG}
+			}
+			for _, mode := range modes {
+				options := append([]dataurl.ParseOption{dataurl.WithParseMode(mode)}, tc.Options...)
+
+				_, parseErr := dataurl.Parse([]byte(tc.Data), options...)
+				_, decodeErr := io.ReadAll(dataurl.NewDecoder(strings.NewReader(tc.Data), options...))
+				_, _, appendErr := dataurl.AppendDecode(nil, []byte(tc.Data), options...)
+				for _, err := range []error{parseErr, decodeErr, appendErr} {
+					if !tc.Error {
+						require.NoError(t, err, `parsing should succeed (mode = %d)`, mode)
+						continue
+					}
+					require.True(t, errors.Is(err, dataurl.ErrTooLarge), `error should match dataurl.ErrTooLarge (mode = %d): %v`, mode, err)
+
+					var pe *dataurl.ParseError
+					require.True(t, errors.As(err, &pe), `error should be a *dataurl.ParseError (mode = %d)`, mode)
+					require.Equal(t, tc.Offset, pe.Offset, `offsets should match (mode = %d)`, mode)
+				}
+			}
 		})
 	}
 
+	t.Run(`Decoder stops reading at the limit`, func(t *testing.T) {
+		src := io.MultiReader(strings.NewReader(`data:,`+strings.Repeat(`a`, 100)), failingReader{})
+		data, err := io.ReadAll(dataurl.NewDecoder(src, dataurl.WithMaxEncodedLength(50)))
+		require.True(t, errors.Is(err, dataurl.ErrTooLarge), `error should match dataurl.ErrTooLarge`)
+		require.Equal(t, strings.Repeat(`a`, 44), string(data), `data up to the limit should be read`)
+
+		dec := dataurl.NewDecoder(io.MultiReader(strings.NewReader(`data:`+strings.Repeat(`a`, 100)), failingReader{}), dataurl.WithMaxHeaderLength(10))
+		_, err = dec.MediaType()
+		require.True(t, errors.Is(err, dataurl.ErrTooLarge), `error should match dataurl.ErrTooLarge`)
+	})
+	t.Run(`large input is rejected without allocating`, func(t *testing.T) {
+		if raceEnabled {
+			t.Skip(`allocations cannot be counted reliably with the race detector`)
+		}
+		testcases := []struct {
+			Name    string
+			Data    []byte
+			Options []dataurl.ParseOption
+		}{
+			{
+				Name:    `payload`,
+				Data:    []byte(`data:;base64,` + strings.Repeat(`QUFB`, 1<<18)),
+				Options: []dataurl.ParseOption{dataurl.WithMaxDecodedLength(1024)},
+			},
+			{
+				Name:    `header`,
+				Data:    []byte(`data:text/plain` + strings.Repeat(`;a=b`, 1<<18) + `,hello`),
+				Options: []dataurl.ParseOption{dataurl.WithMaxHeaderLength(1024)},
+			},
+			{
+				Name:    `parameters`,
+				Data:    []byte(`data:text/plain` + strings.Repeat(`;a=b`, 1<<18) + `,hello`),
+				Options: []dataurl.ParseOption{dataurl.WithMaxParams(16)},
+			},
+		}
+		for _, tc := range testcases {
+			for _, mode := range []dataurl.ParseMode{dataurl.ParseModeDefault, dataurl.ParseModeWHATWG} {
+				options := append([]dataurl.ParseOption{dataurl.WithParseMode(mode)}, tc.Options...)
+				allocs := testing.AllocsPerRun(10, func() {
+					_, _ = dataurl.Parse(tc.Data, options...)
+				})
+				require.LessOrEqual(t, allocs, float64(10), `dataurl.Parse should not allocate the %s (mode = %d)`, tc.Name, mode)
+				require.Less(t, allocatedBytes(func() {
+					_, _ = dataurl.Parse(tc.Data, options...)
+				}), uint64(len(tc.Data)), `dataurl.Parse should not copy the input (%s, mode = %d)`, tc.Name, mode)
+			}
+		}
+	})
+}
+
+// allocatedBytes returns the number of bytes that are allocated by f
+func allocatedBytes(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestVerify(t *testing.T) {
+	const png = `iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==`
+	testcases := []struct {
+		Name       string
+		Data       string
+		Tolerances []dataurl.Tolerance
+		Sniffed    string
+		Error      bool
+	}{
+		{Name: `matching image`, Data: `data:image/png;base64,` + png},
+		{Name: `HTML disguised as an image`, Data: `data:image/png,%3Chtml%3E%3Cscript%3E%3C%2Fscript%3E`, Sniffed: `text/html`, Error: true},
+		{Name: `image disguised as text`, Data: `data:text/plain;base64,` + png, Sniffed: `image/png`, Error: true},
+		{Name: `CSS sniffed as text`, Data: `data:text/css,body%7B%7D`},
+		{Name: `JSON sniffed as text`, Data: `data:application/json,%7B%22a%22%3A1%7D`},
+		{Name: `unknown content`, Data: `data:application/octet-stream,%3Chtml%3E`},
+		{Name: `unknown binary data`, Data: `data:image/x-icon;base64,AAABAAEAAAAA`},
+		{Name: `exact matches only`, Data: `data:text/css,body%7B%7D`, Tolerances: []dataurl.Tolerance{}, Sniffed: `text/plain`, Error: true},
+		{Name: `custom tolerance`, Data: `data:image/png,%3Chtml%3E`, Tolerances: []dataurl.Tolerance{{Declared: `image/*`, Sniffed: `text/html`}}},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			var verifyOptions []dataurl.VerifyOption
+			parseOptions := []dataurl.ParseOption{dataurl.WithVerify(true)}
+			if tc.Tolerances != nil {
+				verifyOptions = append(verifyOptions, dataurl.WithTolerances(tc.Tolerances))
+				parseOptions = append(parseOptions, dataurl.WithTolerances(tc.Tolerances))
+			}
+
+			u, err := dataurl.Parse([]byte(tc.Data))
+			require.NoError(t, err, `dataurl.Parse should succeed without verification`)
+
+			err = u.Verify(verifyOptions...)
+			if !tc.Error {
+				require.NoError(t, err, `u.Verify should succeed`)
+			} else {
+				var mismatch *dataurl.MediaTypeMismatch
+				require.True(t, errors.As(err, &mismatch), `error should be a *dataurl.MediaTypeMismatch`)
+				require.Equal(t, u.MediaType.Type, mismatch.Declared.Type, `declared media types should match`)
+				require.Equal(t, tc.Sniffed, mismatch.Sniffed.Type, `sniffed media types should match`)
+			}
+
+			_, err = dataurl.Parse([]byte(tc.Data), parseOptions...)
+			if !tc.Error {
+				require.NoError(t, err, `dataurl.Parse should succeed`)
+				return
+			}
+			require.True(t, errors.Is(err, dataurl.ErrMediaTypeMismatch), `error should match dataurl.ErrMediaTypeMismatch`)
+			var pe *dataurl.ParseError
+			require.True(t, errors.As(err, &pe), `error should be a *dataurl.ParseError`)
+			require.Equal(t, dataurl.KindMediaTypeMismatch, pe.Kind, `error kinds should match`)
+			var mismatch *dataurl.MediaTypeMismatch
+			require.True(t, errors.As(err, &mismatch), `error should wrap a *dataurl.MediaTypeMismatch`)
+		})
+	}
+
+	t.Run(`empty data`, func(t *testing.T) {
+		u := dataurl.URL{MediaType: dataurl.MediaType{Type: `image/png`}}
+		require.NoError(t, u.Verify(), `u.Verify should succeed`)
+	})
+}
+
+func TestSniffer(t *testing.T) {
+	t.Run(`WebSniffer`, func(t *testing.T) {
+		testcases := []struct {
+			Name     string
+			Data     []byte
+			Expected string
+		}{
+			{Name: `SVG`, Data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), Expected: `image/svg+xml`},
+			{Name: `SVG with prolog`, Data: []byte("\xef\xbb\xbf<?xml version=\"1.0\"?>\n<!-- icon -->\n<!DOCTYPE svg>\n<svg>"), Expected: `image/svg+xml`},
+			{Name: `JSON object`, Data: []byte(` {"hello": ["world", 1, true]}`), Expected: `application/json`},
+			{Name: `truncated JSON array`, Data: []byte(`[{"hello": "wor`), Expected: `application/json`},
+			{Name: `CSS rule`, Data: []byte("/* site */\nbody > p.note {\n  margin: 0;\n}"), Expected: `text/css; charset=utf-8`},
+			{Name: `CSS at-rule`, Data: []byte(`@import url("a.css");`), Expected: `text/css; charset=utf-8`},
+			{Name: `WOFF`, Data: []byte("wOFF\x00\x01\x00\x00"), Expected: `font/woff`},
+			{Name: `WOFF2`, Data: []byte("wOF2\x00\x01\x00\x00"), Expected: `font/woff2`},
+			{Name: `AVIF`, Data: []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf"), Expected: `image/avif`},
+			{Name: `HEIC`, Data: []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"), Expected: `image/heic`},
+			{Name: `WebP`, Data: []byte("RIFF\x24\x00\x00\x00WEBPVP8L"), Expected: `image/webp`},
+			{Name: `ICO`, Data: []byte("\x00\x00\x01\x00\x01\x00\x10\x10"), Expected: `image/x-icon`},
+			{Name: `WebAssembly`, Data: []byte("\x00asm\x01\x00\x00\x00"), Expected: `application/wasm`},
+			{Name: `PDF`, Data: []byte("%PDF-1.7\n"), Expected: `application/pdf`},
+			{Name: `plain text`, Data: []byte(`hello, world`), Expected: `text/plain; charset=utf-8`},
+			{Name: `JavaScript is not CSS`, Data: []byte(`function f() { return 1; }`), Expected: `text/plain; charset=utf-8`},
+			{Name: `invalid JSON`, Data: []byte(`{hello}`), Expected: `text/plain; charset=utf-8`},
+			{Name: `HTML`, Data: []byte(`<!DOCTYPE html><svg></svg>`), Expected: `text/html; charset=utf-8`},
+		}
+		for _, tc := range testcases {
+			tc := tc
+			t.Run(tc.Name, func(t *testing.T) {
+				require.Equal(t, tc.Expected, dataurl.WebSniffer{}.Sniff(tc.Data), `media types should match`)
+			})
+		}
+	})
+	t.Run(`Encode`, func(t *testing.T) {
+		data := []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`)
+		encoded, err := dataurl.Encode(data)
+		require.NoError(t, err, `dataurl.Encode should succeed`)
+		require.True(t, bytes.HasPrefix(encoded, []byte(`data:text/plain;charset=utf-8,`)), `HTTPSniffer should be used by default`)
+
+		encoded, err = dataurl.Encode(data, dataurl.WithSniffer(dataurl.WebSniffer{}))
+		require.NoError(t, err, `dataurl.Encode should succeed`)
+		require.True(t, bytes.HasPrefix(encoded, []byte(`data:image/svg+xml;base64,`)), `WebSniffer should be used`)
+
+		var buf bytes.Buffer
+		enc := dataurl.NewEncoder(&buf, dataurl.WithSniffer(dataurl.WebSniffer{}))
+		_, err = enc.Write(data)
+		require.NoError(t, err, `enc.Write should succeed`)
+		require.NoError(t, enc.Close(), `enc.Close should succeed`)
+		require.Equal(t, string(encoded), buf.String(), `NewEncoder should use the sniffer`)
+
+		_, err = dataurl.Encode(data, dataurl.WithSniffer(dataurl.SnifferFunc(func([]byte) string { return `not a media type` })))
+		require.Error(t, err, `dataurl.Encode should fail when the sniffer returns an invalid media type`)
+	})
+	t.Run(`only the first 512 bytes are sniffed`, func(t *testing.T) {
+		var sniffed int
+		sniffer := dataurl.WithSniffer(dataurl.SnifferFunc(func(data []byte) string {
+			sniffed = len(data)
+			return `text/plain`
+		}))
+		data := bytes.Repeat([]byte(`a`), 4096)
+
+		_, err := dataurl.Encode(data, sniffer)
+		require.NoError(t, err, `dataurl.Encode should succeed`)
+		require.Equal(t, 512, sniffed, `dataurl.Encode should pass 512 bytes to the sniffer`)
+
+		// the smallest encoding strategy holds back the entire payload
+		sniffed = 0
+		enc := dataurl.NewEncoder(io.Discard, sniffer, dataurl.WithEncodingStrategy(dataurl.EncodingStrategySmallest))
+		_, err = enc.Write(data)
+		require.NoError(t, err, `enc.Write should succeed`)
+		require.NoError(t, enc.Close(), `enc.Close should succeed`)
+		require.Equal(t, 512, sniffed, `NewEncoder should pass 512 bytes to the sniffer`)
+
+		sniffed = 0
+		u := &dataurl.URL{MediaType: dataurl.MediaType{Type: `text/plain`}, Data: data}
+		require.NoError(t, u.Verify(sniffer), `u.Verify should succeed`)
+		require.Equal(t, 512, sniffed, `u.Verify should pass 512 bytes to the sniffer`)
+	})
+	t.Run(`Verify`, func(t *testing.T) {
+		u, err := dataurl.Parse([]byte(`data:image/svg+xml,%3Csvg%3E%3C%2Fsvg%3E`))
+		require.NoError(t, err, `dataurl.Parse should succeed`)
+		require.NoError(t, u.Verify(dataurl.WithSniffer(dataurl.WebSniffer{}), dataurl.WithTolerances(nil)), `u.Verify should succeed`)
+
+		sniffer := dataurl.SnifferFunc(func([]byte) string { return `text/html` })
+		require.Error(t, u.Verify(dataurl.WithSniffer(sniffer)), `u.Verify should use the sniffer`)
+
+		_, err = dataurl.Parse([]byte(`data:image/svg+xml,%3Csvg%3E%3C%2Fsvg%3E`), dataurl.WithVerify(true), dataurl.WithSniffer(sniffer))
+		require.True(t, errors.Is(err, dataurl.ErrMediaTypeMismatch), `dataurl.Parse should use the sniffer`)
+	})
+}
+
+func TestText(t *testing.T) {
+	testcases := []struct {
+		Name     string
+		Input    string
+		Expected string
+		Charset  string // set if dataurl.UnsupportedCharset is expected
+		Offset   int    // checked if dataurl.InvalidByteSequence is expected
+		Invalid  bool
+	}{
+		{Name: `default charset`, Input: `data:,hello%2C%20world`, Expected: `hello, world`},
+		{Name: `default charset without parameters`, Input: `data:text/plain,caf%E9`, Invalid: true, Offset: 3},
+		{Name: `US-ASCII`, Input: `data:text/plain;charset=US-ASCII,hello`, Expected: `hello`},
+		{Name: `UTF-8`, Input: `data:text/plain;charset=utf-8,caf%C3%A9`, Expected: `café`},
+		{Name: `UTF-8 with BOM`, Input: `data:text/plain;charset=UTF8,%EF%BB%BFcaf%C3%A9`, Expected: `café`},
+		{Name: `invalid UTF-8`, Input: `data:text/plain;charset=utf-8,ab%C3%28`, Invalid: true, Offset: 2},
+		{Name: `ISO-8859-1`, Input: `data:text/plain;charset=iso-8859-1,caf%E9%80`, Expected: "café\u0080"},
+		{Name: `Latin1 alias`, Input: `data:text/plain;charset=Latin1,%FF`, Expected: `ÿ`},
+		{Name: `Windows-1252`, Input: `data:text/plain;charset=windows-1252,%93caf%E9%94%20%80`, Expected: `“café” €`},
+		{Name: `undefined Windows-1252 byte`, Input: `data:text/plain;charset=cp1252,ab%81`, Invalid: true, Offset: 2},
+		{Name: `UTF-16BE`, Input: `data:text/plain;charset=utf-16be,%00h%00i%D8%3D%DE%00`, Expected: `hi😀`},
+		{Name: `UTF-16LE with BOM`, Input: `data:text/plain;charset=UTF-16LE,%FF%FEh%00i%00`, Expected: `hi`},
+		{Name: `UTF-16 without BOM`, Input: `data:text/plain;charset=utf-16,%00h%00i`, Expected: `hi`},
+		{Name: `UTF-16 with little endian BOM`, Input: `data:text/plain;charset=utf-16,%FF%FEh%00i%00`, Expected: `hi`},
+		{Name: `odd UTF-16 length`, Input: `data:text/plain;charset=utf-16be,%00h%00`, Invalid: true, Offset: 2},
+		{Name: `unpaired UTF-16 surrogate`, Input: `data:text/plain;charset=utf-16le,h%00%3D%D8i%00`, Invalid: true, Offset: 2},
+		{Name: `unknown charset`, Input: `data:text/plain;charset=klingon,hello`, Charset: `klingon`},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			u, err := dataurl.Parse([]byte(tc.Input))
+			require.NoError(t, err, `dataurl.Parse should succeed`)
+
+			text, err := u.Text()
+			switch {
+			case tc.Charset != "":
+				var unsupported *dataurl.UnsupportedCharset
+				require.ErrorAs(t, err, &unsupported, `u.Text should fail with dataurl.UnsupportedCharset`)
+				require.Equal(t, tc.Charset, unsupported.Charset, `charsets should match`)
+			case tc.Invalid:
+				var invalid *dataurl.InvalidByteSequence
+				require.ErrorAs(t, err, &invalid, `u.Text should fail with dataurl.InvalidByteSequence`)
+				require.Equal(t, tc.Offset, invalid.Offset, `offsets should match`)
+			default:
+				require.NoError(t, err, `u.Text should succeed`)
+				require.Equal(t, tc.Expected, text, `text should match`)
+			}
+		})
+	}
+}
+
+func TestCharset(t *testing.T) {
+	testcases := []struct {
+		Name        string
+		Data        string
+		Options     []dataurl.EncodeOption
+		Expected    string
+		Unsupported bool
+		Rune        rune // set if dataurl.UnrepresentableCharacter is expected
+		Offset      int
+	}{
+		{
+			Name:     `ISO-8859-1`,
+			Data:     `café`,
+			Options:  []dataurl.EncodeOption{dataurl.WithCharset(`ISO-8859-1`)},
+			Expected: `data:text/plain;charset=ISO-8859-1,caf%E9`,
+		},
+		{
+			Name:     `Windows-1252 overrides the charset of the media type`,
+			Data:     `“café” €`,
+			Options:  []dataurl.EncodeOption{dataurl.WithMediaType(`text/plain;charset=utf-8`), dataurl.WithCharset(`windows-1252`)},
+			Expected: `data:text/plain;charset=windows-1252,%93caf%E9%94%20%80`,
+		},
+		{
+			Name:     `UTF-16 with byte order mark`,
+			Data:     `hi😀`,
+			Options:  []dataurl.EncodeOption{dataurl.WithMediaType(`text/plain`), dataurl.WithCharset(`UTF-16`)},
+			Expected: `data:text/plain;charset=UTF-16,%FE%FF%00h%00i%D8%3D%DE%00`,
+		},
+		{
+			Name:     `UTF-16LE`,
+			Data:     `hi`,
+			Options:  []dataurl.EncodeOption{dataurl.WithMediaType(`text/plain`), dataurl.WithCharset(`utf-16le`), dataurl.WithMediaTypeParams(map[string]string{`charset`: `utf-8`})},
+			Expected: `data:text/plain;charset=utf-16le,h%00i%00`,
+		},
+		{
+			Name:     `no data`,
+			Options:  []dataurl.EncodeOption{dataurl.WithMediaType(`text/plain`), dataurl.WithCharset(`UTF-16`)},
+			Expected: `data:text/plain;charset=UTF-16,`,
+		},
+		{
+			Name:    `unrepresentable character`,
+			Data:    `price: 5€`,
+			Options: []dataurl.EncodeOption{dataurl.WithCharset(`ISO-8859-1`)},
+			Rune:    '€',
+			Offset:  8,
+		},
+		{
+			Name:    `US-ASCII`,
+			Data:    `naïve`,
+			Options: []dataurl.EncodeOption{dataurl.WithCharset(`us-ascii`)},
+			Rune:    'ï',
+			Offset:  2,
+		},
+		{
+			Name:        `unsupported charset`,
+			Data:        `hello`,
+			Options:     []dataurl.EncodeOption{dataurl.WithCharset(`klingon`)},
+			Unsupported: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			encoded, err := dataurl.Encode([]byte(tc.Data), tc.Options...)
+
+			var dst bytes.Buffer
+			enc := dataurl.NewEncoder(&dst, tc.Options...)
+			var streamErr error
+			for _, b := range []byte(tc.Data) {
+				if _, streamErr = enc.Write([]byte{b}); streamErr != nil {
+					break
+				}
+			}
+			if streamErr == nil {
+				streamErr = enc.Close()
+			}
+
+			switch {
+			case tc.Unsupported:
+				var unsupported *dataurl.UnsupportedCharset
+				require.ErrorAs(t, err, &unsupported, `dataurl.Encode should fail with dataurl.UnsupportedCharset`)
+				require.ErrorAs(t, streamErr, &unsupported, `NewEncoder should fail with dataurl.UnsupportedCharset`)
+			case tc.Rune != 0:
+				var unrepresentable *dataurl.UnrepresentableCharacter
+				require.ErrorAs(t, err, &unrepresentable, `dataurl.Encode should fail with dataurl.UnrepresentableCharacter`)
+				require.Equal(t, tc.Rune, unrepresentable.Rune, `runes should match`)
+				require.Equal(t, tc.Offset, unrepresentable.Offset, `offsets should match`)
+				require.Contains(t, err.Error(), string(tc.Rune), `error should mention the character`)
+
+				require.ErrorAs(t, streamErr, &unrepresentable, `NewEncoder should fail with dataurl.UnrepresentableCharacter`)
+				require.Equal(t, tc.Offset, unrepresentable.Offset, `offsets should match`)
+			default:
+				require.NoError(t, err, `dataurl.Encode should succeed`)
+				require.Equal(t, tc.Expected, string(encoded), `results should match`)
+				require.NoError(t, streamErr, `NewEncoder should succeed`)
+				require.Equal(t, tc.Expected, dst.String(), `NewEncoder should produce the same output`)
+
+				u, err := dataurl.Parse(encoded, dataurl.WithParseMode(dataurl.ParseModeLenient))
+				require.NoError(t, err, `dataurl.Parse should succeed`)
+				text, err := u.Text()
+				require.NoError(t, err, `u.Text should succeed`)
+				require.Equal(t, tc.Data, text, `text should survive the round trip`)
+			}
+		})
+	}
+
+	t.Run(`incomplete UTF-8 sequence`, func(t *testing.T) {
+		data := []byte("caf\xc3")
+		_, err := dataurl.Encode(data, dataurl.WithCharset(`ISO-8859-1`))
+		var invalid *dataurl.InvalidByteSequence
+		require.ErrorAs(t, err, &invalid, `dataurl.Encode should fail with dataurl.InvalidByteSequence`)
+		require.Equal(t, 3, invalid.Offset, `offsets should match`)
+
+		enc := dataurl.NewEncoder(io.Discard, dataurl.WithMediaType(`text/plain`), dataurl.WithCharset(`ISO-8859-1`))
+		_, err = enc.Write(data)
+		require.NoError(t, err, `enc.Write should hold back the incomplete sequence`)
+		require.ErrorAs(t, enc.Close(), &invalid, `enc.Close should fail with dataurl.InvalidByteSequence`)
+		require.Equal(t, 3, invalid.Offset, `offsets should match`)
+	})
+	t.Run(`smallest encoding of the transcoded data`, func(t *testing.T) {
+		data := []byte(strings.Repeat(`héllo`, 100))
+		options := []dataurl.EncodeOption{dataurl.WithCharset(`UTF-16LE`), dataurl.WithEncodingStrategy(dataurl.EncodingStrategySmallest)}
+		encoded, err := dataurl.Encode(data, options...)
+		require.NoError(t, err, `dataurl.Encode should succeed`)
+		require.True(t, bytes.HasPrefix(encoded, []byte(`data:text/plain;charset=UTF-16LE;base64,`)), `UTF-16 text should be base64 encoded`)
+
+		var dst bytes.Buffer
+		enc := dataurl.NewEncoder(&dst, options...)
+		_, err = enc.Write(data)
+		require.NoError(t, err, `enc.Write should succeed`)
+		require.NoError(t, enc.Close(), `enc.Close should succeed`)
+		require.Equal(t, string(encoded), dst.String(), `NewEncoder should produce the same output`)
+	})
+}
+
+func TestParseError(t *testing.T) {
+	testcases := []struct {
+		Name     string
+		Data     string
+		Mode     dataurl.ParseMode
+		Kind     dataurl.ErrorKind
+		Offset   int
+		Sentinel error
+	}{
+		{
+			Name:     `invalid scheme`,
+			Data:     `http://example.com`,
+			Kind:     dataurl.KindInvalidScheme,
+			Offset:   0,
+			Sentinel: dataurl.ErrInvalidScheme,
+		},
+		{
+			Name:     `no data`,
+			Data:     `data:text/plain`,
+			Kind:     dataurl.KindNoData,
+			Offset:   15,
+			Sentinel: dataurl.ErrNoData,
+		},
+		{
+			Name:     `invalid media type`,
+			Data:     `data:text/(plain),hello`,
+			Kind:     dataurl.KindInvalidMediaType,
+			Offset:   5,
+			Sentinel: dataurl.ErrInvalidMediaType,
+		},
+		{
+			Name:     `invalid base64`,
+			Data:     `data:;base64,aGVs*G8=`,
+			Kind:     dataurl.KindInvalidBase64,
+			Offset:   17,
+			Sentinel: dataurl.ErrInvalidBase64,
+		},
+		{
+			Name:     `invalid escape`,
+			Data:     `data:,hello%2`,
+			Kind:     dataurl.KindInvalidEscape,
+			Offset:   11,
+			Sentinel: dataurl.ErrInvalidEscape,
+		},
+		{
+			Name:     `invalid hexadecimal sequence`,
+			Data:     `data:,hello%zzworld`,
+			Kind:     dataurl.KindInvalidEscape,
+			Offset:   11,
+			Sentinel: dataurl.ErrInvalidEscape,
+		},
+		{
+			Name:     `reserved character`,
+			Data:     `data:,hello world`,
+			Kind:     dataurl.KindReservedCharacter,
+			Offset:   11,
+			Sentinel: dataurl.ErrReservedCharacter,
+		},
+		{
+			Name:     `strict mode, invalid parameter`,
+			Data:     `data:text/plain;charset=utf-8;foo,hello`,
+			Mode:     dataurl.ParseModeStrict,
+			Kind:     dataurl.KindInvalidMediaType,
+			Offset:   30,
+			Sentinel: dataurl.ErrInvalidMediaType,
+		},
+		{
+			Name:     `strict mode, invalid escape in parameter value`,
+			Data:     `data:text/plain;charset=utf%2,hello`,
+			Mode:     dataurl.ParseModeStrict,
+			Kind:     dataurl.KindInvalidEscape,
+			Offset:   27,
+			Sentinel: dataurl.ErrInvalidEscape,
+		},
+		{
+			Name:     `lenient mode, leading whitespace`,
+			Data:     `  data:;base64,aGVs*G8=  `,
+			Mode:     dataurl.ParseModeLenient,
+			Kind:     dataurl.KindInvalidBase64,
+			Offset:   19,
+			Sentinel: dataurl.ErrInvalidBase64,
+		},
+		{
+			Name:     `WHATWG mode, invalid base64`,
+			Data:     `data:;base64,aGVs*G8=`,
+			Mode:     dataurl.ParseModeWHATWG,
+			Kind:     dataurl.KindInvalidBase64,
+			Offset:   13,
+			Sentinel: dataurl.ErrInvalidBase64,
+		},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := dataurl.Parse([]byte(tc.Data), dataurl.WithParseMode(tc.Mode))
+			require.Error(t, err, `dataurl.Parse should fail`)
+
+			var pe *dataurl.ParseError
+			require.True(t, errors.As(err, &pe), `error should be a *dataurl.ParseError`)
+			require.Equal(t, tc.Kind, pe.Kind, `error kinds should match`)
+			require.Equal(t, tc.Offset, pe.Offset, `offsets should match`)
+			require.True(t, errors.Is(err, tc.Sentinel), `errors.Is should match the sentinel error`)
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	testcases := []struct {
+		Data     []byte
+		Expected []byte
+		Options  []dataurl.EncodeOption
+		Error    bool
+	}{
+		{
+			Data:     []byte(`hello, world!`),
+			Expected: []byte(`data:text/plain;charset=utf-8,hello%2C%20world!`),
+		},
+		{
+			Data: []byte(`{"hello":"world"}`),
+			Options: []dataurl.EncodeOption{
+				dataurl.WithMediaType(`application/json`),
+				dataurl.WithMediaTypeParams(map[string]string{
+					`charset`: `utf-8`,
+				}),
+			},
+			Expected: []byte(`data:application/json;charset=utf-8;base64,eyJoZWxsbyI6IndvcmxkIn0=`),
+		},
+		{
+			Data: []byte(`{"hello":"world"}`),
+			Options: []dataurl.EncodeOption{
+				dataurl.WithMediaType(`application/json; charset=US-ASCII`),
+				dataurl.WithMediaTypeParams(map[string]string{
+					`charset`: `utf-8`,
+				}),
+			},
+			Expected: []byte(`data:application/json;charset=utf-8;base64,eyJoZWxsbyI6IndvcmxkIn0=`),
+		},
+		{
+			Data: []byte(`hello`),
+			Options: []dataurl.EncodeOption{
+				dataurl.WithMediaType(`text/plain;z=1;charset=US-ASCII`),
+				dataurl.WithOrderedParams(orderedParams(`b`, `2`, `a`, `1`, `z`, `3`)),
+			},
+			Expected: []byte(`data:text/plain;z=3;charset=US-ASCII;b=2;a=1,hello`),
+		},
+		{
+			Data: []byte(`<svg/>`),
+			Options: []dataurl.EncodeOption{
+				dataurl.WithMediaType(`Image/SVG+XML ; Title="a \"b\""`),
+			},
+			Expected: []byte(`data:image/svg+xml;title=a%20%22b%22;base64,PHN2Zy8+`),
+		},
+		{
+			Data: []byte(`hello`),
+			Options: []dataurl.EncodeOption{
+				dataurl.WithMediaType(`text`),
+			},
+			Error: true,
+		},
+		{
+			Data: []byte(`p { margin: 0 }`),
+			Options: []dataurl.EncodeOption{
+				dataurl.WithFilename(`assets/Site.CSS`),
+			},
+			Expected: []byte(`data:text/css,p%20%7B%20margin%3A%200%20%7D`),
+		},
+		{
+			Data: []byte(`<svg/>`),
+			Options: []dataurl.EncodeOption{
+				dataurl.WithFilename(`.svg`),
+			},
+			Expected: []byte(`data:image/svg+xml;base64,PHN2Zy8+`),
+		},
+		{
+			Data: []byte(`<svg/>`),
+			Options: []dataurl.EncodeOption{
+				dataurl.WithFilename(`icon.svg`),
+				dataurl.WithMediaType(`text/plain`),
+			},
+			Expected: []byte(`data:text/plain,%3Csvg%2F%3E`),
+		},
+		{
+			Data: []byte(`hello, world!`),
+			Options: []dataurl.EncodeOption{
+				dataurl.WithFilename(`hello.unknown-extension`),
+			},
+			Expected: []byte(`data:text/plain;charset=utf-8,hello%2C%20world!`),
+		},
+		{
+			Data: []byte(`<svg><text>HelloWorldHelloWorldHelloWorld</text></svg>`),
+			Options: []dataurl.EncodeOption{
+				dataurl.WithMediaType(`image/svg+xml`),
+				dataurl.WithEncodingStrategy(dataurl.EncodingStrategySmallest),
+			},
+			Expected: []byte(`data:image/svg+xml,%3Csvg%3E%3Ctext%3EHelloWorldHelloWorldHelloWorld%3C%2Ftext%3E%3C%2Fsvg%3E`),
+		},
+		{
+			Data: []byte(`こんにちは`),
+			Options: []dataurl.EncodeOption{
+				dataurl.WithEncodingStrategy(dataurl.EncodingStrategySmallest),
+			},
+			Expected: []byte(`data:text/plain;charset=utf-8;base64,44GT44KT44Gr44Gh44Gv`),
+		},
+		{
+			Data: []byte(`こんにちは`),
+			Options: []dataurl.EncodeOption{
+				dataurl.WithEncodingStrategy(dataurl.EncodingStrategySmallest),
+				dataurl.WithBase64Encoding(false),
+			},
+			Expected: []byte(`data:text/plain;charset=utf-8,%E3%81%93%E3%82%93%E3%81%AB%E3%81%A1%E3%81%AF`),
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(string(tc.Data), func(t *testing.T) {
+			u, err := dataurl.Encode(tc.Data, tc.Options...)
+			if tc.Error {
+				require.Error(t, err, `dataurl.Encode should fail`)
+				return
+			}
+			require.NoError(t, err, `dataurl.Encode should succeed`)
+			require.Equal(t, tc.Expected, u, `results should match`)
+
+			parsed, err := dataurl.Parse(u)
+			require.NoError(t, err, `dataurl.Parse should succeed`)
+			require.Equal(t, tc.Data, parsed.Data, `data should match`)
+		})
+	}
+
+	t.Run(`parameters survive a round trip`, func(t *testing.T) {
+		values := map[string]string{
+			`comma`:     `a,b`,
+			`percent`:   `100%`,
+			`escaped`:   `%41`,
+			`quote`:     `say "hi"`,
+			`space`:     `a b`,
+			`mixed`:     `x; y="%2C", \z`,
+			`empty`:     ``,
+			`non-ascii`: "caf\u00e9",
+			`name*`:     `star`,
+		}
+		for name, value := range values {
+			quoted := strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), `"`, `\"`)
+			variants := []struct {
+				Name    string
+				Options []dataurl.EncodeOption
+			}{
+				{Name: `title`, Options: []dataurl.EncodeOption{dataurl.WithMediaType(`image/svg+xml;title="` + quoted + `"`)}},
+				{Name: `title`, Options: []dataurl.EncodeOption{dataurl.WithMediaType(`image/svg+xml`), dataurl.WithMediaTypeParams(map[string]string{`title`: value})}},
+				{Name: name, Options: []dataurl.EncodeOption{dataurl.WithMediaType(`image/svg+xml`), dataurl.WithMediaTypeParams(map[string]string{name: value})}},
+			}
+			for _, variant := range variants {
+				u, err := dataurl.Encode([]byte(`x`), variant.Options...)
+				require.NoError(t, err, `dataurl.Encode should succeed (%s)`, name)
+
+				parsed, err := dataurl.Parse(u)
+				require.NoError(t, err, `dataurl.Parse should succeed (%q)`, u)
+				require.Equal(t, []byte(`x`), parsed.Data, `data should match (%q)`, u)
+				require.Equal(t, map[string]string{variant.Name: value}, parsed.MediaType.Params, `parameters should match (%q)`, u)
+
+				text, err := parsed.MarshalText()
+				require.NoError(t, err, `parsed.MarshalText should succeed`)
+				require.Equal(t, u, text, `parsed.MarshalText should reproduce the URL`)
+			}
+		}
+	})
+}
+
+func TestEscapeProfile(t *testing.T) {
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+
+	payloads := []struct {
+		Name string
+		Data []byte
+	}{
+		{Name: `SVG`, Data: []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox='0 0 1 1'><path d="M0 0h1v1z" fill="#f00"/></svg>`)},
+		{Name: `all bytes`, Data: all},
+		{Name: `escapes in the embedding contexts`, Data: []byte(`50% &amp; &#34; \" \u0022 \22 ) ?a=b`)},
+		{Name: `trailing space`, Data: []byte(`hello, world `)},
+		{Name: `spaces only`, Data: []byte(`   `)},
+	}
+
+	profiles := []struct {
+		Name    string
+		Profile dataurl.EscapeProfile
+		Check   func(*testing.T, []byte, string)
+	}{
+		{
+			Name:    `strict`,
+			Profile: dataurl.EscapeProfileStrict,
+			Check: func(t *testing.T, data []byte, s string) {
+				expected, err := dataurl.Encode(data, dataurl.WithMediaType(`image/svg+xml`), dataurl.WithBase64Encoding(false))
+				require.NoError(t, err, `dataurl.Encode should succeed`)
+				require.Equal(t, string(expected), s, `output should be the same as the default`)
+			},
+		},
+		{
+			Name:    `CSS`,
+			Profile: dataurl.EscapeProfileCSS,
+			Check: func(t *testing.T, _ []byte, s string) {
+				require.False(t, strings.ContainsAny(s, "\"'\\\n\r\f"), `output should be usable in a quoted CSS url()`)
+			},
+		},
+		{
+			Name:    `HTML attribute`,
+			Profile: dataurl.EscapeProfileHTMLAttribute,
+			Check: func(t *testing.T, _ []byte, s string) {
+				require.False(t, strings.ContainsRune(s, '"'), `output should be usable in a double-quoted attribute`)
+				require.Equal(t, s, html.UnescapeString(s), `output should not contain character references`)
+			},
+		},
+		{
+			Name:    `JSON string`,
+			Profile: dataurl.EscapeProfileJSON,
+			Check: func(t *testing.T, _ []byte, s string) {
+				marshaled, err := json.Marshal(s)
+				require.NoError(t, err, `json.Marshal should succeed`)
+				require.Equal(t, `"`+s+`"`, string(marshaled), `output should not need to be escaped in a JSON string`)
+			},
+		},
+	}
+
+	for _, profile := range profiles {
+		profile := profile
+		t.Run(profile.Name, func(t *testing.T) {
+			for _, payload := range payloads {
+				payload := payload
+				t.Run(payload.Name, func(t *testing.T) {
+					options := []dataurl.EncodeOption{
+						dataurl.WithMediaType(`image/svg+xml`),
+						dataurl.WithBase64Encoding(false),
+						dataurl.WithEscapeProfile(profile.Profile),
+					}
+					encoded, err := dataurl.Encode(payload.Data, options...)
+					require.NoError(t, err, `dataurl.Encode should succeed`)
+					profile.Check(t, payload.Data, string(encoded))
+
+					// ParseModeWHATWG decodes the data the same way web browsers do
+					for _, mode := range []dataurl.ParseMode{dataurl.ParseModeLenient, dataurl.ParseModeWHATWG} {
+						u, err := dataurl.Parse(encoded, dataurl.WithParseMode(mode))
+						require.NoError(t, err, `dataurl.Parse should succeed`)
+						require.Equal(t, payload.Data, u.Data, `data should match`)
+					}
+
+					var dst bytes.Buffer
+					enc := dataurl.NewEncoder(&dst, options...)
+					for _, b := range payload.Data {
+						_, err := enc.Write([]byte{b})
+						require.NoError(t, err, `enc.Write should succeed`)
+					}
+					require.NoError(t, enc.Close(), `enc.Close should succeed`)
+					require.Equal(t, string(encoded), dst.String(), `NewEncoder should produce the same output`)
+				})
+			}
+		})
+	}
+
+	t.Run(`minimal profiles are shorter`, func(t *testing.T) {
+		data := payloads[0].Data
+		strict, err := dataurl.Encode(data, dataurl.WithMediaType(`image/svg+xml`), dataurl.WithBase64Encoding(false))
+		require.NoError(t, err, `dataurl.Encode should succeed`)
+		css, err := dataurl.Encode(data, dataurl.WithMediaType(`image/svg+xml`), dataurl.WithBase64Encoding(false), dataurl.WithEscapeProfile(dataurl.EscapeProfileCSS))
+		require.NoError(t, err, `dataurl.Encode should succeed`)
+		require.Equal(t, `data:image/svg+xml,%3Csvg xmlns=%22http://www.w3.org/2000/svg%22 viewBox=%270 0 1 1%27%3E%3Cpath d=%22M0 0h1v1z%22 fill=%22%23f00%22/%3E%3C/svg%3E`, string(css), `only the required bytes should be escaped`)
+		require.Less(t, len(css), len(strict), `output should be shorter than with EscapeProfileStrict`)
+	})
+}
+
+func TestEncodeSVG(t *testing.T) {
+	testcases := []struct {
+		Name     string
+		Data     string
+		Minified string
+		Expected string
+	}{
+		{
+			Name:     `mini SVG data URI`,
+			Data:     `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 50 50"><path d="M22 38V51L32 32l19-19v12C44 26 43 10 38 0 52 15 49 39 22 38z"/></svg>`,
+			Minified: `<svg xmlns='http://www.w3.org/2000/svg' viewBox='0 0 50 50'><path d='M22 38V51L32 32l19-19v12C44 26 43 10 38 0 52 15 49 39 22 38z'/></svg>`,
+			Expected: `data:image/svg+xml,%3csvg xmlns='http://www.w3.org/2000/svg' viewBox='0 0 50 50'%3e%3cpath d='M22 38V51L32 32l19-19v12C44 26 43 10 38 0 52 15 49 39 22 38z'/%3e%3c/svg%3e`,
+		},
+		{
+			Name:     `whitespace and prolog`,
+			Data:     "\xef\xbb\xbf\n<?xml version=\"1.0\"?>\n<svg\n\txmlns=\"http://www.w3.org/2000/svg\">\n  <!--   icon\n  -->\n  <text x=\"0\"  y=\"10\">Say \"hi\"</text>\n</svg>\n",
+			Minified: `<?xml version='1.0'?> <svg xmlns='http://www.w3.org/2000/svg'> <!-- icon --> <text x='0' y='10'>Say "hi"</text> </svg>`,
+			Expected: `data:image/svg+xml,%3c%3fxml version='1.0'%3f%3e %3csvg xmlns='http://www.w3.org/2000/svg'%3e %3c!-- icon --%3e %3ctext x='0' y='10'%3eSay %22hi%22%3c/text%3e %3c/svg%3e`,
+		},
+		{
+			Name:     `values with single quotes keep double quotes`,
+			Data:     `<svg font-family="'Open Sans', sans-serif" class='a "b"'><title>#1 & 100%</title></svg>`,
+			Minified: `<svg font-family="'Open Sans', sans-serif" class='a "b"'><title>#1 & 100%</title></svg>`,
+			Expected: `data:image/svg+xml,%3csvg font-family=%22'Open Sans'%2c sans-serif%22 class='a %22b%22'%3e%3ctitle%3e%231 %26 100%25%3c/title%3e%3c/svg%3e`,
+		},
+		{
+			Name:     `CDATA sections and xml:space are preserved`,
+			Data:     "<svg xml:space=\"preserve\"><style><![CDATA[\n  a > b { fill: red }\n]]></style><text>a  b</text></svg>",
+			Minified: "<svg xml:space='preserve'><style><![CDATA[\n  a > b { fill: red }\n]]></style><text>a  b</text></svg>",
+			Expected: `data:image/svg+xml,%3csvg xml:space='preserve'%3e%3cstyle%3e%3c!%5bCDATA%5b%0a  a %3e b %7b fill: red %7d%0a%5d%5d%3e%3c/style%3e%3ctext%3ea  b%3c/text%3e%3c/svg%3e`,
+		},
+		{
+			Name:     `scripts and style sheets are preserved`,
+			Data:     "<svg>\n  <script type=\"text/javascript\">// c\nvar x=1</script>\n  <style>a  { fill: red }</style><script/> <text>a  b</text>\n</svg>",
+			Minified: "<svg> <script type='text/javascript'>// c\nvar x=1</script> <style>a  { fill: red }</style><script/> <text>a b</text> </svg>",
+			Expected: `data:image/svg+xml,%3csvg%3e %3cscript type='text/javascript'%3e// c%0avar x=1%3c/script%3e %3cstyle%3ea  %7b fill: red %7d%3c/style%3e%3cscript/%3e %3ctext%3ea b%3c/text%3e %3c/svg%3e`,
+		},
+		{
+			Name:     `unterminated attribute value`,
+			Data:     `<svg class="a  b`,
+			Minified: `<svg class="a  b`,
+			Expected: `data:image/svg+xml,%3csvg class=%22a  b`,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			encoded := dataurl.EncodeSVG([]byte(tc.Data))
+			require.Equal(t, tc.Expected, string(encoded), `results should match`)
+
+			for _, mode := range []dataurl.ParseMode{dataurl.ParseModeLenient, dataurl.ParseModeWHATWG} {
+				u, err := dataurl.Parse(encoded, dataurl.WithParseMode(mode))
+				require.NoError(t, err, `dataurl.Parse should succeed`)
+				require.Equal(t, `image/svg+xml`, u.MediaType.String(), `media types should match`)
+				require.Equal(t, tc.Minified, string(u.Data), `data should be the minified SVG`)
+			}
+		})
+	}
+}
+
+func TestBase64Variant(t *testing.T) {
+	data := []byte{0xfb, 0xff, 0xbf, 0xfe}
+	testcases := []struct {
+		Name     string
+		Variant  dataurl.Base64Variant
+		Expected string
+	}{
+		{
+			Name:     `standard`,
+			Variant:  dataurl.Base64Standard,
+			Expected: `data:application/octet-stream;base64,+/+//g==`,
+		},
+		{
+			Name:     `standard, no padding`,
+			Variant:  dataurl.Base64RawStandard,
+			Expected: `data:application/octet-stream;base64,+/+//g`,
+		},
+		{
+			Name:     `URL-safe`,
+			Variant:  dataurl.Base64URL,
+			Expected: `data:application/octet-stream;base64,-_-__g==`,
+		},
+		{
+			Name:     `URL-safe, no padding`,
+			Variant:  dataurl.Base64RawURL,
+			Expected: `data:application/octet-stream;base64,-_-__g`,
+		},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			encoded, err := dataurl.Encode(data, dataurl.WithMediaType(`application/octet-stream`), dataurl.WithBase64Variant(tc.Variant))
+			require.NoError(t, err, `dataurl.Encode should succeed`)
+			require.Equal(t, tc.Expected, string(encoded), `encoded values should match`)
+
+			u, err := dataurl.Parse(encoded, dataurl.WithBase64Variant(tc.Variant))
+			require.NoError(t, err, `dataurl.Parse should succeed`)
+			require.Equal(t, data, u.Data, `data should match`)
+
+			u, err = dataurl.Parse(encoded, dataurl.WithBase64Variant(dataurl.Base64AutoDetect))
+			require.NoError(t, err, `dataurl.Parse should succeed`)
+			require.Equal(t, data, u.Data, `data should match`)
+
+			if tc.Variant != dataurl.Base64Standard {
+				_, err = dataurl.Parse(encoded)
+				require.Error(t, err, `dataurl.Parse should fail with the default variant`)
+			}
+		})
+	}
+}
+
+func TestIgnoreWhitespace(t *testing.T) {
+	// large enough to span multiple internal chunks
+	data := bytes.Repeat([]byte(`Hello, World! `), 1000)
+
+	// wrap the payload the same way PEM and MIME do
+	var wrapped strings.Builder
+	wrapped.WriteString(`data:text/plain;base64,`)
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		wrapped.WriteString(encoded[:76])
+		wrapped.WriteString("\r\n \t")
+		encoded = encoded[76:]
+	}
+	wrapped.WriteString(encoded)
+	input := []byte(wrapped.String())
+
+	t.Run(`default mode rejects whitespace`, func(t *testing.T) {
+		_, err := dataurl.Parse(input)
+		require.True(t, errors.Is(err, dataurl.ErrInvalidBase64), `dataurl.Parse should fail`)
+	})
+	t.Run(`default mode with WithIgnoreWhitespace(true)`, func(t *testing.T) {
+		u, err := dataurl.Parse(input, dataurl.WithIgnoreWhitespace(true))
+		require.NoError(t, err, `dataurl.Parse should succeed`)
+		require.Equal(t, data, u.Data, `data should match`)
+	})
+	t.Run(`lenient mode ignores whitespace by default`, func(t *testing.T) {
+		u, err := dataurl.Parse(input, dataurl.WithStrict(false))
+		require.NoError(t, err, `dataurl.Parse should succeed`)
+		require.Equal(t, data, u.Data, `data should match`)
+	})
+	t.Run(`lenient mode with WithIgnoreWhitespace(false)`, func(t *testing.T) {
+		_, err := dataurl.Parse(input, dataurl.WithStrict(false), dataurl.WithIgnoreWhitespace(false))
+		require.True(t, errors.Is(err, dataurl.ErrInvalidBase64), `dataurl.Parse should fail`)
+	})
+	t.Run(`auto-detection does not count whitespace`, func(t *testing.T) {
+		u, err := dataurl.Parse([]byte("data:;base64,aGVs\nbG8"), dataurl.WithIgnoreWhitespace(true), dataurl.WithBase64Variant(dataurl.Base64AutoDetect))
+		require.NoError(t, err, `dataurl.Parse should succeed`)
+		require.Equal(t, []byte(`hello`), u.Data, `data should match`)
+	})
+	t.Run(`data after padding`, func(t *testing.T) {
+		_, err := dataurl.Parse([]byte("data:;base64,YQ==\nYQ=="), dataurl.WithIgnoreWhitespace(true))
+		var pe *dataurl.ParseError
+		require.True(t, errors.As(err, &pe), `dataurl.Parse should fail`)
+		require.Equal(t, dataurl.KindInvalidBase64, pe.Kind)
+		require.Equal(t, 18, pe.Offset, `error should point to the first character after the padding`)
+	})
+	t.Run(`invalid character after whitespace`, func(t *testing.T) {
+		_, err := dataurl.Parse([]byte("data:;base64,aG Vs\nb*8="), dataurl.WithIgnoreWhitespace(true))
+		var pe *dataurl.ParseError
+		require.True(t, errors.As(err, &pe), `dataurl.Parse should fail`)
+		require.Equal(t, dataurl.KindInvalidBase64, pe.Kind)
+		require.Equal(t, 20, pe.Offset, `error should point to the invalid character`)
+	})
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New(`payload should not be read`)
+}
+
+func TestDecoder(t *testing.T) {
+	t.Run(`large base64 payload`, func(t *testing.T) {
+		data := bytes.Repeat([]byte{0x00, 0x01, 0xfe, 0xff, 'a'}, 100000)
+		input := `data:application/octet-stream;base64,` + base64.StdEncoding.EncodeToString(data)
+
+		dec := dataurl.NewDecoder(iotest.OneByteReader(strings.NewReader(input)))
+		mt, err := dec.MediaType()
+		require.NoError(t, err, `dec.MediaType should succeed`)
+		require.Equal(t, `application/octet-stream`, mt.Type, `media types should match`)
+
+		var dst bytes.Buffer
+		_, err = io.Copy(&dst, dec)
+		require.NoError(t, err, `io.Copy should succeed`)
+		require.Equal(t, data, dst.Bytes(), `data should match`)
+	})
+	t.Run(`media type is available before the payload is read`, func(t *testing.T) {
+		dec := dataurl.NewDecoder(io.MultiReader(strings.NewReader(`data:text/plain;charset=utf-8,`), failingReader{}))
+		mt, err := dec.MediaType()
+		require.NoError(t, err, `dec.MediaType should succeed`)
+		require.Equal(t, `text/plain`, mt.Type, `media types should match`)
+		require.Equal(t, params(`charset`, `utf-8`), mt.Params, `parameters should match`)
+
+		_, err = io.ReadAll(dec)
+		require.Error(t, err, `reading the payload should fail`)
+	})
+	t.Run(`Read without calling MediaType`, func(t *testing.T) {
+		data, err := io.ReadAll(dataurl.NewDecoder(strings.NewReader(`data:,Hello%2C%20World!`)))
+		require.NoError(t, err, `io.ReadAll should succeed`)
+		require.Equal(t, []byte(`Hello, World!`), data, `data should match`)
+	})
+	t.Run(`options are honored`, func(t *testing.T) {
+		dec := dataurl.NewDecoder(
+			strings.NewReader("data:;base64,-_-_\n-_8"),
+			dataurl.WithBase64Variant(dataurl.Base64RawURL),
+			dataurl.WithIgnoreWhitespace(true),
+		)
+		data, err := io.ReadAll(dec)
+		require.NoError(t, err, `io.ReadAll should succeed`)
+		require.Equal(t, []byte{0xfb, 0xff, 0xbf, 0xfb, 0xff}, data, `data should match`)
+	})
+
+	testcases := []struct {
+		Name    string
+		Input   string
+		Options []dataurl.ParseOption
+		Kind    dataurl.ErrorKind
+		Offset  int
+	}{
+		{
+			Name:   `invalid scheme`,
+			Input:  `http://example.com`,
+			Kind:   dataurl.KindInvalidScheme,
+			Offset: 0,
+		},
+		{
+			Name:   `no comma`,
+			Input:  `data:text/plain`,
+			Kind:   dataurl.KindNoData,
+			Offset: 15,
+		},
+		{
+			Name:   `invalid media type`,
+			Input:  `data:text/plain;charset,hello`,
+			Kind:   dataurl.KindInvalidMediaType,
+			Offset: 5,
+		},
+		{
+			Name:    `reserved character`,
+			Input:   `data:,hello world`,
+			Options: []dataurl.ParseOption{dataurl.WithStrict(true)},
+			Kind:    dataurl.KindReservedCharacter,
+			Offset:  11,
+		},
+		{
+			Name:   `invalid escape`,
+			Input:  `data:,hello%2`,
+			Kind:   dataurl.KindInvalidEscape,
+			Offset: 11,
+		},
+		{
+			Name:   `invalid base64`,
+			Input:  `data:;base64,aGVs*G8=`,
+			Kind:   dataurl.KindInvalidBase64,
+			Offset: 17,
+		},
+		{
+			Name:   `truncated base64`,
+			Input:  `data:;base64,aGVsbG8`,
+			Kind:   dataurl.KindInvalidBase64,
+			Offset: 20,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := io.ReadAll(dataurl.NewDecoder(strings.NewReader(tc.Input), tc.Options...))
+			var pe *dataurl.ParseError
+			require.True(t, errors.As(err, &pe), `reading from the decoder should fail with *dataurl.ParseError`)
+			require.Equal(t, tc.Kind, pe.Kind, `error kinds should match`)
+			require.Equal(t, tc.Offset, pe.Offset, `error offsets should match`)
+		})
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New(`write failed`)
+}
+
+func TestEncoder(t *testing.T) {
+	image, err := base64.StdEncoding.DecodeString(`iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==`)
+	require.NoError(t, err, `base64.StdEncoding.DecodeString should succeed`)
+
+	testcases := []struct {
+		Name      string
+		Data      []byte
+		Options   []dataurl.EncodeOption
+		ChunkSize int
+	}{
+		{
+			Name:      `binary data in small chunks`,
+			Data:      bytes.Repeat(image, 100),
+			ChunkSize: 7,
+		},
+		{
+			Name:      `text data in a single chunk`,
+			Data:      bytes.Repeat([]byte(`Hello, World! `), 1000),
+			ChunkSize: 14000,
+		},
+		{
+			Name:      `text data shorter than the detection window`,
+			Data:      []byte(`Hello, World!`),
+			ChunkSize: 1,
+		},
+		{
+			Name:      `explicit media type`,
+			Data:      bytes.Repeat([]byte{0x00, 0xff}, 1000),
+			Options:   []dataurl.EncodeOption{dataurl.WithMediaType(`application/octet-stream`), dataurl.WithBase64Variant(dataurl.Base64RawURL)},
+			ChunkSize: 3,
+		},
+		{
+			Name:      `smallest encoding of the entire payload`,
+			Data:      append(bytes.Repeat([]byte(`Hello, World! `), 100), strings.Repeat(`こんにちは`, 1000)...),
+			Options:   []dataurl.EncodeOption{dataurl.WithEncodingStrategy(dataurl.EncodingStrategySmallest)},
+			ChunkSize: 100,
+		},
+		{
+			Name:      `no data`,
+			ChunkSize: 1,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			expected, err := dataurl.Encode(tc.Data, tc.Options...)
+			require.NoError(t, err, `dataurl.Encode should succeed`)
+
+			var dst bytes.Buffer
+			enc := dataurl.NewEncoder(&dst, tc.Options...)
+			for data := tc.Data; len(data) > 0; {
+				n := tc.ChunkSize
+				if n > len(data) {
+					n = len(data)
+				}
+				written, err := enc.Write(data[:n])
+				require.NoError(t, err, `enc.Write should succeed`)
+				require.Equal(t, n, written, `enc.Write should consume all data`)
+				data = data[n:]
+			}
+			require.NoError(t, enc.Close(), `enc.Close should succeed`)
+			require.Equal(t, string(expected), dst.String(), `results should match dataurl.Encode`)
+		})
+	}
+
+	t.Run(`media type detection holds back the first 512 bytes`, func(t *testing.T) {
+		var dst bytes.Buffer
+		enc := dataurl.NewEncoder(&dst)
+		_, err := enc.Write(image[:50])
+		require.NoError(t, err, `enc.Write should succeed`)
+		require.Zero(t, dst.Len(), `nothing should be written yet`)
+
+		_, err = enc.Write(bytes.Repeat([]byte{0x00}, 512))
+		require.NoError(t, err, `enc.Write should succeed`)
+		require.True(t, strings.HasPrefix(dst.String(), `data:image/png;base64,`), `header should be written`)
+		require.NoError(t, enc.Close(), `enc.Close should succeed`)
+	})
+	t.Run(`write after close`, func(t *testing.T) {
+		enc := dataurl.NewEncoder(io.Discard)
+		require.NoError(t, enc.Close(), `enc.Close should succeed`)
+		_, err := enc.Write([]byte(`hello`))
+		require.Error(t, err, `enc.Write should fail`)
+	})
+	t.Run(`write error`, func(t *testing.T) {
+		enc := dataurl.NewEncoder(failingWriter{}, dataurl.WithMediaType(`text/plain`))
+		_, err := enc.Write([]byte(`hello`))
+		require.Error(t, err, `enc.Write should fail`)
+		require.Error(t, enc.Close(), `enc.Close should fail`)
+	})
+}
+
+func TestAppendEncode(t *testing.T) {
+	data := []byte(`{"hello":"world"}`)
+	options := []dataurl.EncodeOption{dataurl.WithMediaType(`application/json`)}
+
+	expected, err := dataurl.Encode(data, options...)
+	require.NoError(t, err, `dataurl.Encode should succeed`)
+
+	prefix := []byte(`url(`)
+	encoded, err := dataurl.AppendEncode(prefix, data, options...)
+	require.NoError(t, err, `dataurl.AppendEncode should succeed`)
+	require.Equal(t, `url(`+string(expected), string(encoded), `data URL should be appended to dst`)
+
+	_, err = dataurl.AppendEncode(prefix, data, dataurl.WithMediaType(`application/json; charset`), dataurl.WithMediaTypeParams(map[string]string{`foo`: `bar`}))
+	require.Error(t, err, `dataurl.AppendEncode should fail`)
+
+	// parameters are formatted in the same way whether or not they
+	// need to be parsed
+	for mediaType, expected := range map[string]string{
+		`Text/Plain; charset=utf-8`:      `data:text/plain;charset=utf-8,x`,
+		`text/plain ;a=1 ; b=2 `:         `data:text/plain;a=1;b=2,x`,
+		`text/plain;b=1;a=2`:             `data:text/plain;b=1;a=2,x`,
+		`text/plain;A=1`:                 `data:text/plain;a=1,x`,
+		`text/plain;a="1"`:               `data:text/plain;a=1,x`,
+		`text/plain;title*=utf-8''%41`:   `data:text/plain;title=A,x`,
+		`text/plain;charset=utf-8;a=%7e`: `data:text/plain;charset=utf-8;a=%257e,x`,
+	} {
+		encoded, err := dataurl.AppendEncode(nil, []byte(`x`), dataurl.WithMediaType(mediaType))
+		require.NoError(t, err, `dataurl.AppendEncode should succeed`)
+		require.Equal(t, expected, string(encoded), `data URLs should match (media type = %q)`, mediaType)
+	}
+
+	if raceEnabled {
+		t.Skip(`allocations cannot be counted reliably with the race detector`)
+	}
+	buf := make([]byte, 0, 1024)
+	for _, data := range [][]byte{data, []byte(`Hello, World!`)} {
+		allocs := testing.AllocsPerRun(100, func() {
+			_, _ = dataurl.AppendEncode(buf[:0], data, options...)
+		})
+		require.Zero(t, allocs, `dataurl.AppendEncode should not allocate`)
+	}
+
+	// the sniffed `text/plain; charset=utf-8` is not parsed either
+	text := []byte(`Hello, World!`)
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = dataurl.AppendEncode(buf[:0], text)
+	})
+	require.Zero(t, allocs, `dataurl.AppendEncode should not allocate`)
+}
+
+func TestAppendDecode(t *testing.T) {
+	testcases := []struct {
+		Name      string
+		Input     string
+		Options   []dataurl.ParseOption
+		MediaType string
+		Data      string
+		Error     bool
+	}{
+		{
+			Name:      `base64`,
+			Input:     `data:application/json;charset=utf-8;base64,eyJoZWxsbyI6IndvcmxkIn0=`,
+			MediaType: `application/json;charset=utf-8`,
+			Data:      `{"hello":"world"}`,
+		},
+		{
+			Name:      `escaped`,
+			Input:     `data:,hello%2C%20world!`,
+			MediaType: ``,
+			Data:      `hello, world!`,
+		},
+		{
+			Name:      `lenient mode`,
+			Input:     ` DATA:text/plain ; BASE64 ,aGVs bG8= `,
+			Options:   []dataurl.ParseOption{dataurl.WithStrict(false)},
+			MediaType: `text/plain `,
+			Data:      `hello`,
+		},
+		{
+			Name:      `WHATWG mode`,
+			Input:     "data:text/plain;base64,aGVs\tbG8=#fragment",
+			Options:   []dataurl.ParseOption{dataurl.WithParseMode(dataurl.ParseModeWHATWG)},
+			MediaType: `text/plain`,
+			Data:      `hello`,
+		},
+		{
+			Name:  `invalid base64`,
+			Input: `data:;base64,aGVs*G8=`,
+			Error: true,
+		},
+		{
+			Name:  `no comma`,
+			Input: `data:text/plain`,
+			Error: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			prefix := []byte(`prefix:`)
+			mt, data, err := dataurl.AppendDecode(prefix, []byte(tc.Input), tc.Options...)
+			if tc.Error {
+				require.Error(t, err, `dataurl.AppendDecode should fail`)
+				require.Equal(t, `prefix:`, string(data), `dst should be returned as is`)
+				return
+			}
+			require.NoError(t, err, `dataurl.AppendDecode should succeed`)
+			require.Equal(t, tc.MediaType, string(mt), `media types should match`)
+			require.Equal(t, `prefix:`+tc.Data, string(data), `data should be appended to dst`)
+		})
+	}
+
+	if raceEnabled {
+		t.Skip(`allocations cannot be counted reliably with the race detector`)
+	}
+	buf := make([]byte, 0, 1024)
+	for _, tc := range []struct {
+		Input   string
+		Options []dataurl.ParseOption
+	}{
+		{Input: testcases[0].Input},
+		{Input: testcases[1].Input},
+		{Input: `data:text/plain; charset="utf-8" ;a=%41;base64,aGVsbG8=`},
+		{Input: `data:text/plain;charset=%22utf-8%22;base64,aGVsbG8=`, Options: []dataurl.ParseOption{dataurl.WithStrict(true)}},
+	} {
+		src := []byte(tc.Input)
+		allocs := testing.AllocsPerRun(100, func() {
+			_, _, _ = dataurl.AppendDecode(buf[:0], src, tc.Options...)
+		})
+		require.Zero(t, allocs, `dataurl.AppendDecode should not allocate (input = %q)`, tc.Input)
+	}
+
+	// the WHATWG mode normalizes the input, but decodes into dst
+	src := []byte(`data:;base64,` + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0xfe, 0xff, 'a'}, 1<<16)))
+	buf = make([]byte, 0, len(src))
+	allocated := allocatedBytes(func() {
+		_, _, _ = dataurl.AppendDecode(buf[:0], src, dataurl.WithParseMode(dataurl.ParseModeWHATWG))
+	})
+	require.Less(t, allocated, uint64(len(src)+len(src)/2), `dataurl.AppendDecode should only copy the input once`)
+}
+
+func TestAppendDecodeMediaType(t *testing.T) {
+	// AppendDecode validates the media type without parsing it, and
+	// must report the same errors as Parse
+	inputs := []string{
+		``,
+		`text/plain`,
+		` Text/Plain `,
+		`text`,
+		`text/`,
+		`/plain`,
+		`text/(plain)`,
+		`text/plain/x`,
+		`text/plain;charset`,
+		`text/plain;charset=`,
+		`text/plain;=utf-8`,
+		`text/plain;charset=utf-8;`,
+		`text/plain;charset=utf-8; ; `,
+		`text/plain;charset=utf-8;;`,
+		`text/plain; charset = "utf-8" `,
+		`text/plain;a="x`,
+		`text/plain;a="x\"y"`,
+		`text/plain;a=""`,
+		`text/plain;a=%22x%22`,
+		`text/plain;a=%22x`,
+		`text/plain;a=%22x%5C%22`,
+		`text/plain;a=%22x%5C%22y%22`,
+		`text/plain;a=%22%22`,
+		`text/plain;a=%22`,
+		`text/plain;a=1;a=2`,
+		`text/plain;a=1;A=1`,
+		`text/plain;a=1;a="1"`,
+		`text/plain;a=%zz`,
+		`text/plain;a="%4"`,
+		`text/plain;a%zz=1`,
+		`text/plain;a%2Db=1;a-b=2`,
+		`text/plain;a%2db=1;A%2Db=1`,
+		`text/plain;%41=1;a=1`,
+		`text/plain;a=x%20y`,
+		`text/plain;a=x y`,
+		`text/plain;a=x'y`,
+		`text%2Fplain`,
+		`text%2F(plain)`,
+		`%`,
+		`;charset=utf-8`,
+		`;`,
+		`text/plain;title*=utf-8''%25zz`,
+		`text/plain;title*=utf-8''%41`,
+		"text/plain;a=\u00e9",
+		"text/plain;\u00a0a=1",
+	}
+
+	modes := []struct {
+		Name    string
+		Options []dataurl.ParseOption
+	}{
+		{Name: `default`},
+		{Name: `strict`, Options: []dataurl.ParseOption{dataurl.WithStrict(true)}},
+		{Name: `lenient`, Options: []dataurl.ParseOption{dataurl.WithParseMode(dataurl.ParseModeLenient)}},
+		{Name: `WHATWG`, Options: []dataurl.ParseOption{dataurl.WithParseMode(dataurl.ParseModeWHATWG)}},
+	}
+
+	for _, mode := range modes {
+		mode := mode
+		t.Run(mode.Name, func(t *testing.T) {
+			for _, input := range inputs {
+				for _, marker := range []string{``, `;base64`} {
+					requireSameAsParse(t, []byte(`data:`+input+marker+`,aGVsbG8=`), mode.Options...)
+				}
+			}
+		})
+	}
+}
+
+func TestURLMarshal(t *testing.T) {
+	inputs := []string{
+		`data:text/plain;charset=utf-8,hello%2C%20world!`,
+		`data:text/plain;charset=utf-8;base64,aGVsbG8sIHdvcmxkIQ==`,
+		`data:application/json;base64,eyJoZWxsbyI6IndvcmxkIn0=`,
+		`data:application/json,%7B%22hello%22%3A%22world%22%7D`,
+	}
+	for _, input := range inputs {
+		input := input
+		t.Run(input, func(t *testing.T) {
+			u, err := dataurl.Parse([]byte(input))
+			require.NoError(t, err, `dataurl.Parse should succeed`)
+			require.Equal(t, input, u.String(), `u.String should reproduce the input`)
+
+			text, err := u.MarshalText()
+			require.NoError(t, err, `u.MarshalText should succeed`)
+			require.Equal(t, input, string(text), `u.MarshalText should reproduce the input`)
+
+			var unmarshaled dataurl.URL
+			require.NoError(t, unmarshaled.UnmarshalText(text), `UnmarshalText should succeed`)
+			require.Equal(t, u, &unmarshaled, `URLs should match`)
+
+			bin, err := u.MarshalBinary()
+			require.NoError(t, err, `u.MarshalBinary should succeed`)
+			require.Equal(t, input, string(bin), `u.MarshalBinary should reproduce the input`)
+
+			unmarshaled = dataurl.URL{}
+			require.NoError(t, unmarshaled.UnmarshalBinary(bin), `UnmarshalBinary should succeed`)
+			require.Equal(t, u, &unmarshaled, `URLs should match`)
+		})
+	}
+
+	t.Run(`parameter names that are not tokens`, func(t *testing.T) {
+		const input = `data:application/json;charset=utf-8;oddParam1="a\"<@>\"z";odd%20param2=hello%20world;base64,eyJoZWxsbyI6IndvcmxkIn0=`
+		u, err := dataurl.Parse([]byte(input))
+		require.NoError(t, err, `dataurl.Parse should succeed`)
+
+		text, err := u.MarshalText()
+		require.NoError(t, err, `u.MarshalText should succeed`)
+		require.NotEmpty(t, u.String(), `u.String should succeed`)
+
+		reparsed, err := dataurl.Parse(text)
+		require.NoError(t, err, `dataurl.Parse should succeed on the output of u.MarshalText`)
+		require.Equal(t, u.MediaType.Params, reparsed.MediaType.Params, `parameters should survive a round trip`)
+
+		encoded, err := dataurl.Encode([]byte(`x`), dataurl.WithMediaType(`text/plain`), dataurl.WithMediaTypeParams(map[string]string{`odd param`: `x y`}))
+		require.NoError(t, err, `dataurl.Encode should succeed`)
+		reparsed, err = dataurl.Parse(encoded)
+		require.NoError(t, err, `dataurl.Parse should succeed on the output of dataurl.Encode`)
+		require.Equal(t, map[string]string{`odd param`: `x y`}, reparsed.MediaType.Params, `parameters should survive a round trip`)
+
+		_, err = dataurl.Encode([]byte(`x`), dataurl.WithMediaType(`application/json`), dataurl.WithMediaTypeParams(map[string]string{``: `x`}))
+		require.True(t, errors.Is(err, dataurl.ErrInvalidMediaType), `error should match dataurl.ErrInvalidMediaType`)
+		require.Contains(t, err.Error(), `invalid parameter name ""`, `error should name the parameter`)
+
+		_, err = dataurl.URL{MediaType: dataurl.MediaType{Type: `application`}}.MarshalText()
+		require.True(t, errors.Is(err, dataurl.ErrInvalidMediaType), `error should match dataurl.ErrInvalidMediaType`)
+	})
+
+	type icon struct {
+		XMLName xml.Name    `json:"-" xml:"icon"`
+		Name    string      `json:"name" xml:"name,attr"`
+		Image   dataurl.URL `json:"image" xml:"image"`
+	}
+	const image = `data:image/png;base64,iVBORw0KGgo=`
+	u, err := dataurl.Parse([]byte(image))
+	require.NoError(t, err, `dataurl.Parse should succeed`)
+	src := icon{Name: `logo`, Image: *u}
+
+	t.Run(`encoding/json`, func(t *testing.T) {
+		buf, err := json.Marshal(src)
+		require.NoError(t, err, `json.Marshal should succeed`)
+		require.Equal(t, `{"name":"logo","image":"`+image+`"}`, string(buf), `JSON should match`)
+
+		var dst icon
+		require.NoError(t, json.Unmarshal(buf, &dst), `json.Unmarshal should succeed`)
+		require.Equal(t, src, dst, `values should match`)
+
+		require.Error(t, json.Unmarshal([]byte(`{"image":"http://example.com"}`), &dst), `json.Unmarshal should fail`)
+	})
+	t.Run(`encoding/xml`, func(t *testing.T) {
+		buf, err := xml.Marshal(src)
+		require.NoError(t, err, `xml.Marshal should succeed`)
+		require.Equal(t, `<icon name="logo"><image>`+image+`</image></icon>`, string(buf), `XML should match`)
+
+		var dst icon
+		require.NoError(t, xml.Unmarshal(buf, &dst), `xml.Unmarshal should succeed`)
+		require.Equal(t, src.Name, dst.Name, `names should match`)
+		require.Equal(t, src.Image, dst.Image, `images should match`)
+	})
+	t.Run(`encoding/gob`, func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, gob.NewEncoder(&buf).Encode(src.Image), `gob encoding should succeed`)
+
+		var dst dataurl.URL
+		require.NoError(t, gob.NewDecoder(&buf).Decode(&dst), `gob decoding should succeed`)
+		require.Equal(t, src.Image, dst, `values should match`)
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Run(`unmodified URLs reproduce the input`, func(t *testing.T) {
+		testcases := []struct {
+			Input   string
+			Options []dataurl.ParseOption
+		}{
+			{Input: `data:text/plain;Charset=UTF-8;z=1;a="x y",hello%2c%20world`},
+			{Input: `data:;base64,aGVsbG8sIHdvcmxkIQ==`},
+			{Input: `data:,hello`, Options: []dataurl.ParseOption{dataurl.WithStrict(true)}},
+			{Input: " DATA:text/plain ; BASE64 ,aGVs bG8=\n", Options: []dataurl.ParseOption{dataurl.WithStrict(false)}},
+			{Input: "data:text/html;base64,PGI+aGk8L2I+#frag", Options: []dataurl.ParseOption{dataurl.WithParseMode(dataurl.ParseModeWHATWG)}},
+			{Input: `data:;base64,-_-_`, Options: []dataurl.ParseOption{dataurl.WithBase64Variant(dataurl.Base64RawURL)}},
+		}
+		for _, tc := range testcases {
+			tc := tc
+			t.Run(tc.Input, func(t *testing.T) {
+				u, err := dataurl.Parse([]byte(tc.Input), append(tc.Options, dataurl.WithPreserveInput(true))...)
+				require.NoError(t, err, `dataurl.Parse should succeed`)
+				require.Equal(t, tc.Input, u.String(), `u.String should reproduce the input`)
+			})
+		}
+	})
+	t.Run(`header and parameter order are recorded`, func(t *testing.T) {
+		u, err := dataurl.Parse([]byte(`data:text/plain;z=1;A=2;odd%20param=3;base64,aGVsbG8=`), dataurl.WithPreserveInput(true))
+		require.NoError(t, err, `dataurl.Parse should succeed`)
+		require.Equal(t, `text/plain;z=1;A=2;odd%20param=3;base64`, u.Header, `header should match`)
+		require.Equal(t, []string{`z`, `a`, `odd param`}, u.MediaType.OrderedParams().Names(), `parameter order should match`)
+		require.True(t, u.Base64, `base64 should be recorded`)
+	})
+	t.Run(`modified data keeps the original header`, func(t *testing.T) {
+		u, err := dataurl.Parse([]byte(`data:text/plain;Charset=UTF-8;z=1,hello`), dataurl.WithPreserveInput(true))
+		require.NoError(t, err, `dataurl.Parse should succeed`)
+		u.Data = []byte(`good bye`)
+		require.Equal(t, `data:text/plain;Charset=UTF-8;z=1,good%20bye`, u.String(), `header should be kept`)
+
+		u.Data[0] = 'G'
+		require.Equal(t, `data:text/plain;Charset=UTF-8;z=1,Good%20bye`, u.String(), `in-place modifications should be detected`)
+
+		u.Data = []byte(`hello`)
+		require.Equal(t, `data:text/plain;Charset=UTF-8;z=1,hello`, u.String(), `restored data should reproduce the input`)
+		u.Data[1], u.Data[4] = u.Data[4], u.Data[1]
+		require.Equal(t, `data:text/plain;Charset=UTF-8;z=1,holle`, u.String(), `swapped bytes should be detected`)
+	})
+	t.Run(`UnmarshalText does not preserve the input`, func(t *testing.T) {
+		const input = `data:text/plain;Charset=UTF-8,hello%2c%20world`
+		var u dataurl.URL
+		require.NoError(t, u.UnmarshalText([]byte(input)), `UnmarshalText should succeed`)
+		require.Equal(t, ``, u.Header, `header should not be recorded`)
+		require.Equal(t, `data:text/plain;charset=UTF-8,hello%2C%20world`, u.String(), `URL should be encoded again`)
+	})
+	t.Run(`modified media type is encoded in the original order`, func(t *testing.T) {
+		u, err := dataurl.Parse([]byte(`data:text/plain;z=1;a=2,hello`), dataurl.WithPreserveInput(true))
+		require.NoError(t, err, `dataurl.Parse should succeed`)
+		u.MediaType.Params[`z`] = `3`
+		require.Equal(t, `data:text/plain;z=3;a=2,hello`, u.String(), `parameters should be in the original order`)
+
+		u.MediaType.Params[`c`] = `4`
+		u.MediaType.Params[`b`] = `5`
+		require.Equal(t, `data:text/plain;z=3;a=2;b=5;c=4,hello`, u.String(), `new parameters should come last, in sorted order`)
+
+		delete(u.MediaType.Params, `z`)
+		require.Equal(t, `data:text/plain;a=2;b=5;c=4,hello`, u.String(), `deleted parameters should be omitted`)
+	})
+	t.Run(`input is not preserved by default`, func(t *testing.T) {
+		u, err := dataurl.Parse([]byte(`data:text/plain;z=1;Charset=UTF-8,hello`))
+		require.NoError(t, err, `dataurl.Parse should succeed`)
+		require.Equal(t, ``, u.Header, `header should not be recorded`)
+		require.Equal(t, `data:text/plain;z=1;charset=UTF-8,hello`, u.String(), `URL should be encoded again`)
+	})
+	t.Run(`parameter order is recorded by default`, func(t *testing.T) {
+		const input = `data:text/plain;b=1;a=2,hello`
+		modes := []dataurl.ParseMode{dataurl.ParseModeDefault, dataurl.ParseModeStrict, dataurl.ParseModeLenient, dataurl.ParseModeWHATWG}
+		for _, mode := range modes {
+			u, err := dataurl.Parse([]byte(input), dataurl.WithParseMode(mode))
+			require.NoError(t, err, `dataurl.Parse should succeed (mode = %d)`, mode)
+			require.Equal(t, []string{`b`, `a`}, u.MediaType.OrderedParams().Names(), `parameter order should match (mode = %d)`, mode)
+			require.Equal(t, input, u.String(), `u.String should keep the order (mode = %d)`, mode)
+
+			mt, err := dataurl.NewDecoder(strings.NewReader(input), dataurl.WithParseMode(mode)).MediaType()
+			require.NoError(t, err, `dec.MediaType should succeed (mode = %d)`, mode)
+			require.Equal(t, []string{`b`, `a`}, mt.OrderedParams().Names(), `parameter order should match (mode = %d)`, mode)
+		}
+	})
+	t.Run(`modified encoding`, func(t *testing.T) {
+		u, err := dataurl.Parse([]byte(`data:text/plain,hello`), dataurl.WithPreserveInput(true))
+		require.NoError(t, err, `dataurl.Parse should succeed`)
+		u.Base64 = true
+		require.Equal(t, `data:text/plain;base64,aGVsbG8=`, u.String(), `payload should be base64 encoded`)
+	})
 }
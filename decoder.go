@@ -0,0 +1,585 @@
+package dataurl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Decoder reads a data URL from a stream. Only the header is read
+// before the media type becomes available, and the payload is decoded
+// incrementally as it is read, so that large payloads can be processed
+// using a constant amount of memory.
+type Decoder struct {
+	src           *bufio.Reader
+	parser        *parser
+	started       bool // true once the header has been read
+	mt            MediaType
+	payload       io.Reader
+	payloadOffset int
+	decoded       int // number of bytes decoded so far
+	err           error
+}
+
+// NewDecoder creates a Decoder that reads a data URL from src. It accepts
+// the same options as Parse.
+//
+// The Decoder may buffer data beyond what it has consumed from src.
+func NewDecoder(src io.Reader, options ...ParseOption) *Decoder {
+	p := newParser(options)
+	if p.maxEncoded > 0 {
+		src = &limitReader{src: src, parser: p}
+	}
+	return &Decoder{
+		src:    bufio.NewReader(src),
+		parser: p,
+	}
+}
+
+// MediaType reads the header of the data URL if it has not been
+// read yet, and returns its media type.
+func (dec *Decoder) MediaType() (MediaType, error) {
+	if err := dec.readHeader(); err != nil {
+		return MediaType{}, err
+	}
+	return dec.mt, nil
+}
+
+// Read reads the decoded payload of the data URL into p. Errors found
+// in the payload are reported as *ParseError.
+func (dec *Decoder) Read(p []byte) (int, error) {
+	if err := dec.readHeader(); err != nil {
+		return 0, err
+	}
+	if dec.err != nil {
+		return 0, dec.err
+	}
+
+	n, err := dec.payload.Read(p)
+	if maxDecoded := dec.parser.maxDecoded; maxDecoded > 0 && dec.decoded+n > maxDecoded {
+		n = maxDecoded - dec.decoded
+		err = dec.parser.decodedTooLarge(dec.payloadOffset)
+	}
+	dec.decoded += n
+	if err != nil {
+		dec.err = err
+	}
+	return n, err
+}
+
+func (dec *Decoder) readHeader() error {
+	if !dec.started {
+		dec.started = true
+		dec.err = dec.parseHeader()
+	}
+	if dec.payload == nil {
+		return dec.err
+	}
+	return nil
+}
+
+func (dec *Decoder) parseHeader() error {
+	var header []byte
+	var found bool
+	for !found {
+		chunk, err := dec.src.ReadSlice(',')
+		header = append(header, chunk...)
+		if err := dec.checkHeaderLength(header); err != nil {
+			return err
+		}
+		switch err {
+		case nil:
+			found = true
+		case bufio.ErrBufferFull:
+		case io.EOF:
+			// no comma: the scheme is checked below so that the
+			// same error is reported as in Parse
+			if dec.parser.mode == ParseModeWHATWG {
+				return whatwgHeaderError(header, false)
+			}
+			if _, err := dec.parser.skipScheme(header); err != nil {
+				return err
+			}
+			return newParseError(KindNoData, len(header), nil)
+		default:
+			return err
+		}
+	}
+	offset := len(header)
+	header = header[:len(header)-1]
+	dec.payloadOffset = offset
+
+	p := dec.parser
+	if p.mode == ParseModeWHATWG {
+		if err := whatwgHeaderError(header, true); err != nil {
+			return err
+		}
+		// as in appendWHATWG, the limits are checked against the
+		// original input rather than the normalized header
+		headerOffset := bytes.IndexByte(header, ':') + 1
+		if err := p.checkHeader(header[headerOffset:], headerOffset); err != nil {
+			return err
+		}
+		serialized := serializeWHATWG(header, true)[len(scheme):]
+		mimeType, isBase64 := splitWHATWGHeader(serialized)
+		dec.mt = whatwgMediaType(mimeType)
+		if err := p.checkPolicy(dec.mt, serialized, headerOffset); err != nil {
+			return err
+		}
+		dec.payload = p.payloadReader(dec.src, offset, isBase64)
+		return nil
+	}
+
+	start, err := p.skipScheme(header)
+	if err != nil {
+		return err
+	}
+	if err := p.checkHeader(header[start:], start); err != nil {
+		return err
+	}
+
+	mt, isBase64, err := p.parseHeader(header[start:], start)
+	if err != nil {
+		return err
+	}
+	if err := p.checkPolicy(mt, header[start:], start); err != nil {
+		return err
+	}
+
+	if p.mode == ParseModeDefault {
+		if _, err := dec.src.Peek(1); err == io.EOF {
+			return newParseError(KindNoData, offset, nil)
+		}
+	}
+
+	dec.mt = mt
+	dec.payload = p.payloadReader(dec.src, offset, isBase64)
+	return nil
+}
+
+// checkHeaderLength fails early if header, which is the part of the
+// input read so far while looking for the comma, cannot possibly fit
+// within the header length limit. The exact limit is checked once the
+// comma has been found.
+func (dec *Decoder) checkHeaderLength(header []byte) error {
+	maxHeader := dec.parser.maxHeader
+	if maxHeader <= 0 {
+		return nil
+	}
+
+	// skip leading whitespace and control characters, which are
+	// allowed in the lenient and the WHATWG modes
+	var start int
+	for start < len(header) && header[start] <= 0x20 {
+		start++
+	}
+	// the header is followed by the comma
+	if len(header)-start > len(scheme)+maxHeader+1 {
+		return newParseError(KindTooLarge, start+len(scheme)+maxHeader, fmt.Errorf(`header exceeds the limit of %d bytes`, maxHeader))
+	}
+	return nil
+}
+
+// whatwgHeaderError checks the section of the input up to the first
+// comma (excluding the comma) as the WHATWG data: URL processor would.
+// found is false if the input does not contain a comma.
+func whatwgHeaderError(header []byte, found bool) error {
+	if !hasScheme(serializeWHATWG(header, found)) {
+		return newParseError(KindInvalidScheme, 0, nil)
+	}
+	if !found {
+		return newParseError(KindNoData, len(header), nil)
+	}
+	if i := bytes.IndexByte(header, '#'); i > -1 {
+		// the comma is part of the fragment, which is ignored
+		return newParseError(KindNoData, i, nil)
+	}
+	return nil
+}
+
+// payloadReader returns a reader that decodes the payload of a data URL
+// as it is read from src. offset is the position of the payload within
+// the original input.
+//
+// The payload is decoded by a chain of readers, each mirroring one
+// step of appendPayload.
+func (p *parser) payloadReader(src io.Reader, offset int, isBase64 bool) io.Reader {
+	switch p.mode {
+	case ParseModeStrict:
+		if !isBase64 {
+			return &unescapeReader{chunk: chunk{src: src}, offset: offset, allowed: isURLChar}
+		}
+		return p.base64Reader(src, offset, true)
+	case ParseModeLenient, ParseModeWHATWG:
+		whatwg := p.mode == ParseModeWHATWG
+		trim := isSpace
+		if whatwg {
+			trim = isC0ControlOrSpace
+		}
+		src = &unescapeReader{chunk: chunk{src: &trimReader{chunk: chunk{src: src}, trim: trim, whatwg: whatwg}}, lenient: true}
+		if !isBase64 {
+			return src
+		}
+		return p.base64Reader(src, offset, false)
+	default:
+		if !isBase64 {
+			return &unescapeReader{chunk: chunk{src: src}, offset: offset, allowed: isNotReserved}
+		}
+		return p.base64Reader(src, offset, true)
+	}
+}
+
+func isC0ControlOrSpace(c byte) bool {
+	return c <= 0x20
+}
+
+// chunkSize is the number of bytes that the stages of payloadReader
+// read from their source at once
+const chunkSize = 4096
+
+// chunk holds the bytes that a stage of payloadReader has read from src
+// but not processed yet
+type chunk struct {
+	src io.Reader
+	mem []byte
+	buf []byte // bytes that have not been processed yet
+	err error  // error returned by src, if any
+}
+
+// fill reads more data from src, after the bytes that have not been
+// processed yet
+func (c *chunk) fill() {
+	if c.mem == nil {
+		c.mem = make([]byte, chunkSize)
+	}
+	n := copy(c.mem, c.buf)
+	m, err := c.src.Read(c.mem[n:])
+	c.buf = c.mem[:n+m]
+	c.err = err
+}
+
+// trimReader removes trailing bytes for which trim returns true. If
+// whatwg is true, it also emulates the URL parser by removing ASCII tab
+// and newline characters, and by stopping at the start of the fragment.
+type trimReader struct {
+	chunk
+	trim   func(byte) bool
+	whatwg bool
+	held   []byte // bytes that will be dropped if they are at the end
+	out    []byte // bytes that are ready to be returned
+	done   bool
+}
+
+func (r *trimReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		if len(r.out) > 0 {
+			n := copy(p, r.out)
+			r.out = r.out[n:]
+			return n, nil
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		if len(r.buf) == 0 {
+			if r.err != nil {
+				return 0, r.err
+			}
+			r.fill()
+			continue
+		}
+		if n := r.trimChunk(p); n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// trimChunk copies the bytes that have been read from src to p, until
+// p is full or held bytes turn out not to be at the end
+func (r *trimReader) trimChunk(p []byte) int {
+	var n, i int
+	for i < len(r.buf) && n < len(p) {
+		c := r.buf[i]
+		i++
+
+		if r.whatwg {
+			if c == '\t' || c == '\n' || c == '\r' {
+				continue
+			}
+			if c == '#' {
+				// the held bytes are not at the end of the input
+				r.done = true
+				r.out, r.held = r.held, r.out[:0]
+				r.buf = nil
+				return n
+			}
+		}
+
+		if r.trim(c) {
+			r.held = append(r.held, c)
+			continue
+		}
+		if len(r.held) > 0 {
+			r.out, r.held = append(r.held, c), r.out[:0]
+			break
+		}
+		p[n] = c
+		n++
+	}
+	r.buf = r.buf[i:]
+	return n
+}
+
+// unescapeReader decodes percent-escaped sequences as they are read.
+//
+// If lenient is true, invalid sequences are returned verbatim. Otherwise
+// they are reported as errors, along with any byte for which allowed
+// returns false, if allowed is non-nil.
+type unescapeReader struct {
+	chunk
+	offset  int // position of the first byte of buf within the original input
+	allowed func(byte) bool
+	lenient bool
+}
+
+func (r *unescapeReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		n, err := r.unescapeChunk(p)
+		if n > 0 || err != nil {
+			return n, err
+		}
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.fill()
+	}
+}
+
+// unescapeChunk decodes the bytes that have been read from src into p,
+// until p is full. It stops before an escaped sequence that is cut off
+// at the end of the bytes read so far, unless src has been exhausted.
+func (r *unescapeReader) unescapeChunk(p []byte) (int, error) {
+	buf := r.buf
+	var n, i int
+	defer func() {
+		r.buf = buf[i:]
+		r.offset += i
+	}()
+
+	for i < len(buf) && n < len(p) {
+		c := buf[i]
+		if c != '%' {
+			if !r.lenient && r.allowed != nil && !r.allowed(c) {
+				return n, newParseError(KindReservedCharacter, r.offset+i, fmt.Errorf(`%q must be percent-escaped`, c))
+			}
+			p[n] = c
+			n++
+			i++
+			continue
+		}
+
+		var digits int
+		for digits < 2 && i+1+digits < len(buf) && isHex(buf[i+1+digits]) {
+			digits++
+		}
+		cut := digits < 2 && i+1+digits == len(buf)
+		if cut && r.err != io.EOF {
+			// the rest of the sequence has not been read yet, or src
+			// failed, in which case the error is returned once the
+			// bytes before the sequence have been
+			return n, nil
+		}
+		if digits == 2 {
+			p[n] = unhex(buf[i+1])<<4 | unhex(buf[i+2])
+			n++
+			i += 3
+			continue
+		}
+		if r.lenient {
+			p[n] = '%'
+			n++
+			i++
+			continue
+		}
+		if cut {
+			return n, newParseError(KindInvalidEscape, r.offset+i, fmt.Errorf(`unexpected end of byte sequence`))
+		}
+		return n, newParseError(KindInvalidEscape, r.offset+i, fmt.Errorf(`invalid hexadecimal sequence %q`, buf[i:i+digits+2]))
+	}
+	return n, nil
+}
+
+// base64Reader returns a reader that decodes base64 data read from src.
+// If exact is false, src has been transformed, so errors are reported
+// at offset instead of at the position of the offending character.
+func (p *parser) base64Reader(src io.Reader, offset int, exact bool) io.Reader {
+	f := &base64Filter{
+		src:       src,
+		start:     offset,
+		offset:    offset,
+		exact:     exact,
+		variant:   p.variant,
+		forgiving: p.mode == ParseModeWHATWG,
+		skipSpace: p.ignoreSpace || p.mode == ParseModeWHATWG,
+	}
+	return &base64Reader{
+		filter:  f,
+		decoder: base64.NewDecoder(base64.RawStdEncoding, f),
+	}
+}
+
+type base64Reader struct {
+	filter  *base64Filter
+	decoder io.Reader
+}
+
+func (r *base64Reader) Read(p []byte) (int, error) {
+	n, err := r.decoder.Read(p)
+	if err != nil && err != io.EOF {
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			err = newParseError(KindInvalidBase64, r.filter.errorOffset(r.filter.offset), err)
+		}
+	}
+	return n, err
+}
+
+// base64Filter validates base64 encoded data as it is read, and
+// translates it to the unpadded standard alphabet, so that it can be
+// decoded using base64.RawStdEncoding regardless of the variant.
+//
+// If forgiving is true, the rules of the "forgiving-base64 decode"
+// algorithm from the WHATWG Infra standard are used instead of variant.
+type base64Filter struct {
+	src       io.Reader
+	start     int // position of the payload within the original input
+	offset    int // position of the next byte from src within the original input
+	exact     bool
+	variant   Base64Variant
+	forgiving bool
+	skipSpace bool
+	n         int // number of characters read so far, including padding
+	pads      int
+	std, url  bool // true if characters specific to each alphabet were found
+}
+
+func (f *base64Filter) errorOffset(pos int) int {
+	if f.exact {
+		return pos
+	}
+	return f.start
+}
+
+// Read reads data from src into p and filters it in place, as no byte
+// is ever replaced by more than one byte
+func (f *base64Filter) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		m, err := f.src.Read(p)
+		var n int
+		for _, c := range p[:m] {
+			pos := f.offset
+			f.offset++
+			if f.pads == 0 && ((c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')) {
+				// fast path for the characters that all variants share
+				f.n++
+				p[n] = c
+				n++
+				continue
+			}
+			c, ok, ferr := f.filter(c, pos)
+			if ferr != nil {
+				return n, ferr
+			}
+			if ok {
+				p[n] = c
+				n++
+			}
+		}
+		if err == io.EOF {
+			if ferr := f.finish(); ferr != nil {
+				return n, ferr
+			}
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// filter validates c, which was found at position pos, and translates
+// it to the standard alphabet. The second return value is false if c
+// must be skipped.
+func (f *base64Filter) filter(c byte, pos int) (byte, bool, error) {
+	// like the decoders in encoding/base64, CR and LF are always ignored
+	if c == '\r' || c == '\n' || (f.skipSpace && isSpace(c)) {
+		return 0, false, nil
+	}
+	f.n++
+
+	if c == '=' {
+		f.pads++
+		if f.pads > 2 || (!f.forgiving && (f.variant == Base64RawStandard || f.variant == Base64RawURL)) {
+			return 0, false, newParseError(KindInvalidBase64, f.errorOffset(pos), fmt.Errorf(`unexpected padding`))
+		}
+		return 0, false, nil
+	}
+	if f.pads > 0 {
+		return 0, false, newParseError(KindInvalidBase64, f.errorOffset(pos), fmt.Errorf(`data after padding`))
+	}
+
+	ch := c
+	switch {
+	case (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return c, true, nil
+	case c == '+' || c == '/':
+		f.std = true
+	case (c == '-' || c == '_') && !f.forgiving:
+		f.url = true
+		if c == '-' {
+			c = '+'
+		} else {
+			c = '/'
+		}
+	default:
+		return 0, false, newParseError(KindInvalidBase64, f.errorOffset(pos), fmt.Errorf(`illegal character %q`, c))
+	}
+
+	var ok bool
+	switch f.variant {
+	case Base64URL, Base64RawURL:
+		ok = !f.std || f.forgiving
+	case Base64AutoDetect:
+		ok = !f.std || !f.url
+	default:
+		ok = !f.url
+	}
+	if !ok {
+		return 0, false, newParseError(KindInvalidBase64, f.errorOffset(pos), fmt.Errorf(`illegal character %q`, ch))
+	}
+	return c, true, nil
+}
+
+// finish validates the padding once all of the data has been read
+func (f *base64Filter) finish() error {
+	if f.n%4 == 0 {
+		return nil
+	}
+	if f.pads > 0 {
+		return newParseError(KindInvalidBase64, f.errorOffset(f.offset), fmt.Errorf(`invalid padding`))
+	}
+	if !f.forgiving && (f.variant == Base64Standard || f.variant == Base64URL) {
+		return newParseError(KindInvalidBase64, f.errorOffset(f.offset), fmt.Errorf(`missing padding`))
+	}
+	return nil
+}
@@ -0,0 +1,556 @@
+package dataurl
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen is the number of bytes that are considered when detecting
+// the media type. This is the same as what "net/http".DetectContentType uses.
+const sniffLen = 512
+
+// escapeChunkSize is the number of bytes that are percent-escaped at once
+const escapeChunkSize = 4096
+
+var errEncoderClosed = errors.New(`dataurl: write to closed encoder`)
+
+// EncodingStrategy specifies how Encode chooses between base64 and
+// percent-encoding when `dataurl.WithBase64Encoding()` is not specified
+type EncodingStrategy int
+
+const (
+	// EncodingStrategyMediaType percent-encodes payloads whose media type
+	// is `text/*`, and base64 encodes everything else. This is the default.
+	EncodingStrategyMediaType EncodingStrategy = iota
+	// EncodingStrategySmallest computes the exact length of the data URL
+	// in both encodings, and uses the one that is shorter. Percent-encoding
+	// is used when both are of the same length.
+	EncodingStrategySmallest
+)
+
+// encodeConfig holds the configuration for encoding a data URL
+type encodeConfig struct {
+	mt             string
+	filename       string
+	params         map[string]string
+	orderedParams  Params
+	explicitBase64 bool // true if the user specified base64
+	encodeBase64   bool
+	variant        Base64Variant
+	strategy       EncodingStrategy
+	profile        EscapeProfile
+	charset        string
+	sniffer        Sniffer
+}
+
+func (c *encodeConfig) apply(options []EncodeOption) {
+	for _, option := range options {
+		switch option.Ident() {
+		case identMediaType{}:
+			c.mt = option.Value().(string)
+		case identFilename{}:
+			c.filename = option.Value().(string)
+		case identMediaTypeParams{}:
+			c.params = option.Value().(map[string]string)
+		case identOrderedParams{}:
+			c.orderedParams = option.Value().(Params)
+		case identBase64Encoding{}:
+			c.explicitBase64 = true
+			c.encodeBase64 = option.Value().(bool)
+		case identBase64Variant{}:
+			c.variant = option.Value().(Base64Variant)
+		case identEncodingStrategy{}:
+			c.strategy = option.Value().(EncodingStrategy)
+		case identEscapeProfile{}:
+			c.profile = option.Value().(EscapeProfile)
+		case identCharset{}:
+			c.charset = option.Value().(string)
+		case identSniffer{}:
+			c.sniffer = option.Value().(Sniffer)
+		}
+	}
+
+	// an explicit media type takes precedence over the filename. If
+	// the extension is unknown, the media type is sniffed
+	if c.mt == "" && c.filename != "" {
+		c.mt = typeByFilename(c.filename)
+	}
+}
+
+// targetCharset returns the charset that the payload is transcoded to,
+// or nil if it is not transcoded
+func (c *encodeConfig) targetCharset() (*charset, error) {
+	if c.charset == "" {
+		return nil, nil
+	}
+	cs := lookupCharset(c.charset)
+	if cs == nil {
+		return nil, &UnsupportedCharset{Charset: c.charset}
+	}
+	return cs, nil
+}
+
+// transcode converts data from UTF-8 to the charset specified using
+// WithCharset. data is returned as is if no charset was specified.
+func (c *encodeConfig) transcode(data []byte) ([]byte, error) {
+	cs, err := c.targetCharset()
+	if err != nil || cs == nil || len(data) == 0 {
+		return data, err
+	}
+
+	dst := append(make([]byte, 0, len(cs.bom)+len(data)), cs.bom...)
+	dst, err = cs.appendEncoded(dst, data, c.charset, 0)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to transcode data: %w`, err)
+	}
+	return dst, nil
+}
+
+// appendHeader appends the header of the data URL to dst, using data
+// to detect the media type if necessary, and payload, which is data
+// after it has been transcoded, to choose the encoding. It also reports
+// whether the payload should be base64 encoded. dst is left untouched
+// on error.
+func (c *encodeConfig) appendHeader(dst, data, payload []byte) ([]byte, bool, error) {
+	s := c.mt
+	if s == "" {
+		s = sniff(c.sniffer, data)
+	}
+
+	// a media type whose parameters would be formatted as they are,
+	// such as the sniffed `text/plain; charset=utf-8`, is used as is,
+	// which avoids allocating memory for the parameters
+	essence, params := splitEssence(s)
+	mt := MediaType{Type: essence}
+	if !isSortedTokenParams(params) || len(c.params) != 0 || c.orderedParams.Len() != 0 || c.charset != "" {
+		params = ""
+		parsed, err := ParseMediaType(s)
+		if err != nil {
+			return dst, false, fmt.Errorf(`failed to parse media type: %w`, err)
+		}
+		mt = parsed
+
+		// params takes precedence over the parameters in the media type
+		for name, value := range c.params {
+			mt.Params[strings.ToLower(name)] = value
+		}
+		if c.orderedParams.Len() != 0 {
+			ordered := mt.OrderedParams()
+			for _, v := range c.orderedParams.list {
+				ordered.Set(v.name, v.value)
+			}
+			mt.SetOrderedParams(ordered)
+		}
+
+		// the charset must match the transcoded payload
+		if c.charset != "" {
+			mt.Params[`charset`] = c.charset
+		}
+	}
+
+	encodeBase64 := c.encodeBase64
+	if !c.explicitBase64 {
+		// The user has not explicitly provided us with the option to
+		// either use or not use base64.
+		switch c.strategy {
+		case EncodingStrategySmallest:
+			encodeBase64 = base64Shorter(c.variant.encoding(), payload, c.profile)
+		default:
+			// We're going to use base64 if and only if the data is
+			// not a text-type
+			if mt.Top() != `text` {
+				// use base64
+				encodeBase64 = true
+			}
+		}
+	}
+
+	orig := dst
+	dst = append(dst, scheme...)
+	dst, err := mt.append(dst, true)
+	if err != nil {
+		return orig, false, fmt.Errorf(`failed to format media type: %w`, err)
+	}
+	dst = appendTokenParams(dst, params)
+
+	if encodeBase64 {
+		dst = append(dst, base64Marker...)
+	}
+	dst = append(dst, ',')
+	return dst, encodeBase64, nil
+}
+
+// isSortedTokenParams reports whether the parameters section of a media
+// type only consists of parameters whose names are in lower case and in
+// strictly increasing order, and whose values are tokens. Such parameters
+// are formatted by MediaType.append in the order in which they appear,
+// and can therefore be formatted without being parsed.
+func isSortedTokenParams(params string) bool {
+	var prev string
+	for len(params) > 0 {
+		name, _, rest, ok := nextTokenParam(params)
+		if !ok || name <= prev || strings.IndexByte(name, '*') > -1 {
+			return false
+		}
+		for i := 0; i < len(name); i++ {
+			if c := name[i]; c >= 'A' && c <= 'Z' {
+				return false
+			}
+		}
+		prev, params = name, rest
+	}
+	return true
+}
+
+// appendTokenParams appends the parameters section of a media type,
+// which must have been checked using isSortedTokenParams, to dst
+func appendTokenParams(dst []byte, params string) []byte {
+	for len(params) > 0 {
+		name, value, rest, _ := nextTokenParam(params)
+		dst = append(dst, ';')
+		dst = appendParamEscaped(dst, name)
+		dst = append(dst, '=')
+		dst = appendParamEscaped(dst, value)
+		params = rest
+	}
+	return dst
+}
+
+// nextTokenParam returns the name and the value of the parameter that
+// params starts with, along with the rest of params. The last return
+// value is false if the parameter is not of the form name=token.
+func nextTokenParam(params string) (string, string, string, bool) {
+	// params always starts with ';' here
+	rest := trimLeftOWS(params[1:])
+	i := strings.IndexByte(rest, '=')
+	if i < 0 || !isTokenString(rest[:i]) {
+		return "", "", "", false
+	}
+	name, rest := rest[:i], rest[i+1:]
+
+	end := strings.IndexAny(rest, "; \t")
+	if end < 0 {
+		end = len(rest)
+	}
+	value, rest := rest[:end], trimLeftOWS(rest[end:])
+	if !isTokenString(value) || (len(rest) > 0 && rest[0] != ';') {
+		return "", "", "", false
+	}
+	return name, value, rest, true
+}
+
+func isTokenString(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// base64Shorter reports whether data base64 encoded using enc, along
+// with the `;base64` marker, is shorter than data percent-escaped
+// using profile
+func base64Shorter(enc *base64.Encoding, data []byte, profile EscapeProfile) bool {
+	return len(base64Marker)+enc.EncodedLen(len(data)) < escapedLen(data, profile)
+}
+
+// holdsAll reports whether the entire payload is needed before the
+// header can be written
+func (c *encodeConfig) holdsAll() bool {
+	return !c.explicitBase64 && c.strategy == EncodingStrategySmallest
+}
+
+// AppendEncode is like Encode, but appends the data URL to dst and
+// returns the extended buffer. If an error occurs, dst is returned as is.
+//
+// Unless the media type has parameters or the data is transcoded using
+// WithCharset, no memory is allocated when dst has enough capacity to
+// hold the result.
+func AppendEncode(dst, data []byte, options ...EncodeOption) ([]byte, error) {
+	var c encodeConfig
+	c.apply(options)
+
+	payload, err := c.transcode(data)
+	if err != nil {
+		return dst, err
+	}
+
+	dst, encodeBase64, err := c.appendHeader(dst, data, payload)
+	if err != nil {
+		return dst, err
+	}
+
+	if !encodeBase64 {
+		return appendEscapedPayload(dst, payload, c.profile), nil
+	}
+
+	return appendBase64Encoded(c.variant.encoding(), dst, payload), nil
+}
+
+// encoder writes a data URL to an io.Writer
+type encoder struct {
+	encodeConfig
+	dst        io.Writer
+	sniff      []byte    // data held back until the media type is known
+	payload    io.Writer // nil until the header has been written
+	base64     io.WriteCloser
+	escape     *escapeWriter
+	transcoder *transcodeWriter
+	closed     bool
+	err        error
+}
+
+// NewEncoder returns a writer that encodes the data written to it into
+// data URL format, and writes it to dst. It accepts the same options
+// as Encode.
+//
+// Unless the media type is specified, the first 512 bytes written are
+// held back and used to detect the media type before anything is written
+// to dst. After that, the payload is written as the data arrives.
+//
+// When `dataurl.WithEncodingStrategy(dataurl.EncodingStrategySmallest)`
+// is specified, the entire payload is held back until Close is called,
+// as its length in both encodings must be known before the header is
+// written.
+//
+// The caller must call Close when done writing, in order to write out
+// any buffered data.
+func NewEncoder(dst io.Writer, options ...EncodeOption) io.WriteCloser {
+	enc := encoder{
+		dst: dst,
+	}
+	enc.apply(options)
+	return &enc
+}
+
+func (enc *encoder) Write(p []byte) (int, error) {
+	if enc.closed {
+		return 0, errEncoderClosed
+	}
+	if enc.err != nil {
+		return 0, enc.err
+	}
+
+	if enc.payload == nil {
+		if enc.holdsAll() || (enc.mt == "" && len(enc.sniff)+len(p) < sniffLen) {
+			enc.sniff = append(enc.sniff, p...)
+			return len(p), nil
+		}
+
+		// only the first sniffLen bytes are needed to detect the media type
+		sniff := p
+		if len(sniff) > sniffLen-len(enc.sniff) {
+			sniff = sniff[:sniffLen-len(enc.sniff)]
+		}
+		if err := enc.writeHeader(append(enc.sniff, sniff...)); err != nil {
+			enc.err = err
+			return 0, err
+		}
+	}
+
+	n, err := enc.payload.Write(p)
+	if err != nil {
+		enc.err = err
+	}
+	return n, err
+}
+
+// Close writes out any data that has been held back, and the remaining
+// base64 encoded data, if any. It does not close the underlying writer.
+func (enc *encoder) Close() error {
+	if enc.closed {
+		return enc.err
+	}
+	enc.closed = true
+	if enc.err != nil {
+		return enc.err
+	}
+
+	if enc.payload == nil {
+		if err := enc.writeHeader(enc.sniff); err != nil {
+			enc.err = err
+			return err
+		}
+	}
+
+	if enc.transcoder != nil {
+		if err := enc.transcoder.Close(); err != nil {
+			enc.err = err
+			return err
+		}
+	}
+	if enc.base64 != nil {
+		if err := enc.base64.Close(); err != nil {
+			enc.err = err
+			return err
+		}
+	}
+	if enc.escape != nil {
+		if err := enc.escape.Close(); err != nil {
+			enc.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHeader writes the header of the data URL, using data to detect
+// the media type if necessary, and then writes out the data that has
+// been held back so far.
+func (enc *encoder) writeHeader(data []byte) error {
+	cs, err := enc.targetCharset()
+	if err != nil {
+		return err
+	}
+
+	// the length of the transcoded payload is only needed when the
+	// entire payload has been held back
+	payload := data
+	if enc.holdsAll() {
+		if payload, err = enc.transcode(data); err != nil {
+			return err
+		}
+	}
+
+	header, encodeBase64, err := enc.appendHeader(nil, data, payload)
+	if err != nil {
+		return err
+	}
+	if _, err := enc.dst.Write(header); err != nil {
+		return err
+	}
+
+	if encodeBase64 {
+		enc.base64 = base64.NewEncoder(enc.variant.encoding(), enc.dst)
+		enc.payload = enc.base64
+	} else {
+		enc.escape = &escapeWriter{dst: enc.dst, profile: enc.profile}
+		enc.payload = enc.escape
+	}
+	if cs != nil {
+		enc.transcoder = &transcodeWriter{dst: enc.payload, charset: cs, name: enc.charset}
+		enc.payload = enc.transcoder
+	}
+
+	sniff := enc.sniff
+	enc.sniff = nil
+	if len(sniff) > 0 {
+		if _, err := enc.payload.Write(sniff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escapeWriter percent-escapes the data written to it
+type escapeWriter struct {
+	dst     io.Writer
+	profile EscapeProfile
+	buf     []byte
+	space   bool // true if a trailing space has been held back
+}
+
+func (w *escapeWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > escapeChunkSize {
+			chunk = chunk[:escapeChunkSize]
+		}
+
+		w.buf = w.buf[:0]
+		if w.space {
+			w.buf = append(w.buf, ' ')
+			w.space = false
+		}
+
+		// a trailing space must be escaped, but whether it is the last
+		// byte of the payload is only known when more data is written,
+		// or when the writer is closed
+		escape := chunk
+		if n := len(escape); escape[n-1] == ' ' && w.profile.isSafe(' ') {
+			escape = escape[:n-1]
+			w.space = true
+		}
+
+		w.buf = appendEscaped(w.buf, escape, w.profile)
+		if _, err := w.dst.Write(w.buf); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// Close writes out the trailing space that has been held back, if any
+func (w *escapeWriter) Close() error {
+	if !w.space {
+		return nil
+	}
+	w.space = false
+	_, err := w.dst.Write([]byte(`%20`))
+	return err
+}
+
+// transcodeWriter converts the UTF-8 text written to it to a charset
+type transcodeWriter struct {
+	dst     io.Writer
+	charset *charset
+	name    string
+	buf     []byte
+	partial []byte // incomplete UTF-8 sequence at the end of the last write
+	offset  int    // number of bytes transcoded so far
+}
+
+func (w *transcodeWriter) Write(p []byte) (int, error) {
+	text := p
+	if len(w.partial) > 0 {
+		text = append(w.partial, p...)
+	}
+
+	// an incomplete UTF-8 sequence is held back until the rest of
+	// it is written
+	n := len(text)
+	for i := n - 1; i >= 0 && i >= n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(text[i]) {
+			if !utf8.FullRune(text[i:]) {
+				n = i
+			}
+			break
+		}
+	}
+
+	w.buf = w.buf[:0]
+	if w.offset == 0 && n > 0 {
+		w.buf = append(w.buf, w.charset.bom...)
+	}
+	buf, err := w.charset.appendEncoded(w.buf, text[:n], w.name, w.offset)
+	if err != nil {
+		return 0, fmt.Errorf(`failed to transcode data: %w`, err)
+	}
+	w.buf = buf
+	w.offset += n
+	w.partial = append([]byte(nil), text[n:]...)
+
+	if _, err := w.dst.Write(w.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close reports an error if the data ends with an incomplete UTF-8 sequence
+func (w *transcodeWriter) Close() error {
+	if len(w.partial) > 0 {
+		return fmt.Errorf(`failed to transcode data: %w`, &InvalidByteSequence{Charset: `UTF-8`, Offset: w.offset})
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package dataurl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorKind describes the category of a ParseError
+type ErrorKind int
+
+const (
+	// KindInvalidScheme means that the input does not start with "data:"
+	KindInvalidScheme ErrorKind = iota + 1
+	// KindNoData means that the input does not contain a data section
+	KindNoData
+	// KindInvalidMediaType means that the media type or one of
+	// its parameters could not be parsed
+	KindInvalidMediaType
+	// KindInvalidBase64 means that the payload could not be decoded as base64
+	KindInvalidBase64
+	// KindInvalidEscape means that a percent-escaped sequence is malformed
+	KindInvalidEscape
+	// KindReservedCharacter means that a character that must be
+	// percent-escaped was found without being escaped
+	KindReservedCharacter
+	// KindPolicyViolation means that the media type is not accepted by
+	// the Policy given to the parser. Err is a *PolicyViolation
+	KindPolicyViolation
+	// KindTooLarge means that the input exceeds one of the size limits
+	// given to the parser
+	KindTooLarge
+	// KindMediaTypeMismatch means that the media type detected from the
+	// data differs from the declared media type. Err is a *MediaTypeMismatch
+	KindMediaTypeMismatch
+)
+
+// Sentinel errors that correspond to each ErrorKind. A *ParseError
+// matches the sentinel for its Kind when compared using `errors.Is()`
+var (
+	ErrInvalidScheme     = errors.New(`invalid scheme`)
+	ErrNoData            = errors.New(`no data`)
+	ErrInvalidMediaType  = errors.New(`invalid media type`)
+	ErrInvalidBase64     = errors.New(`invalid base64 data`)
+	ErrInvalidEscape     = errors.New(`invalid escape sequence`)
+	ErrReservedCharacter = errors.New(`reserved character`)
+	ErrPolicyViolation   = errors.New(`policy violation`)
+	ErrTooLarge          = errors.New(`too large`)
+	ErrMediaTypeMismatch = errors.New(`media type mismatch`)
+)
+
+func (k ErrorKind) sentinel() error {
+	switch k {
+	case KindInvalidScheme:
+		return ErrInvalidScheme
+	case KindNoData:
+		return ErrNoData
+	case KindInvalidMediaType:
+		return ErrInvalidMediaType
+	case KindInvalidBase64:
+		return ErrInvalidBase64
+	case KindInvalidEscape:
+		return ErrInvalidEscape
+	case KindReservedCharacter:
+		return ErrReservedCharacter
+	case KindPolicyViolation:
+		return ErrPolicyViolation
+	case KindTooLarge:
+		return ErrTooLarge
+	case KindMediaTypeMismatch:
+		return ErrMediaTypeMismatch
+	default:
+		return nil
+	}
+}
+
+func (k ErrorKind) String() string {
+	if err := k.sentinel(); err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf(`unknown error kind (%d)`, int(k))
+}
+
+// ParseError is the type of error returned when a data URL cannot be parsed.
+type ParseError struct {
+	// Kind is the category of the error
+	Kind ErrorKind
+	// Offset is the byte offset into the original input where the error
+	// was detected. For errors that are detected after the payload has
+	// been transformed (e.g. percent-decoded before base64 decoding),
+	// this points to the beginning of the payload.
+	Offset int
+	// Err is the underlying cause, if any
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf(`invalid data URL: %s at byte %d`, e.Kind, e.Offset)
+	}
+	return fmt.Sprintf(`invalid data URL: %s at byte %d: %s`, e.Kind, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying cause of the error
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is the sentinel error for e.Kind
+func (e *ParseError) Is(target error) bool {
+	return target != nil && target == e.Kind.sentinel()
+}
+
+func newParseError(kind ErrorKind, offset int, err error) *ParseError {
+	return &ParseError{
+		Kind:   kind,
+		Offset: offset,
+		Err:    err,
+	}
+}
+
+// atOffset overwrites the offset of err if it is a *ParseError. This is
+// used when the precise location of an error within a section of
+// the input cannot be determined.
+func atOffset(err error, offset int) error {
+	var pe *ParseError
+	if errors.As(err, &pe) {
+		pe.Offset = offset
+	}
+	return err
+}
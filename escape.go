@@ -0,0 +1,86 @@
+package dataurl
+
+import "strings"
+
+// EscapeProfile specifies which bytes are percent-escaped when the
+// payload is not base64 encoded. The profiles other than
+// EscapeProfileStrict leave as many bytes as possible unescaped, while
+// making sure that the data URL can be embedded in a specific context
+// without any further escaping, and that it decodes to the same data
+// in web browsers and in Parse with ParseModeLenient.
+//
+// In all profiles, `%`, `#`, ASCII control characters, non-ASCII bytes
+// and a trailing space are escaped, as URL parsers would otherwise
+// interpret, drop or re-encode them.
+type EscapeProfile int
+
+const (
+	// EscapeProfileStrict escapes everything except the unreserved
+	// characters of RFC 2396, which produces data URLs that are valid
+	// in any context. This is the default.
+	EscapeProfileStrict EscapeProfile = iota
+	// EscapeProfileCSS produces data URLs that can be embedded in
+	// a quoted CSS `url("...")` or `url('...')`. Quotes, backslashes,
+	// `<` and `>` are escaped.
+	EscapeProfileCSS
+	// EscapeProfileHTMLAttribute produces data URLs that can be embedded
+	// in a double-quoted HTML attribute value, such as `src="..."`.
+	// Double quotes, `&`, `<` and `>` are escaped.
+	EscapeProfileHTMLAttribute
+	// EscapeProfileJSON produces data URLs that can be embedded in
+	// a JSON string. Double quotes, backslashes, `&`, `<` and `>` are
+	// escaped, the latter three so that the string is the same whether
+	// or not it is HTML-escaped by "encoding/json".
+	EscapeProfileJSON
+)
+
+// unsafe returns the printable ASCII characters that must be escaped,
+// in addition to the ones that are escaped in all profiles
+func (p EscapeProfile) unsafe() string {
+	switch p {
+	case EscapeProfileCSS:
+		return `"'\<>`
+	case EscapeProfileHTMLAttribute:
+		return `"&<>`
+	case EscapeProfileJSON:
+		return `"\&<>`
+	default:
+		return ""
+	}
+}
+
+// isSafe returns true if b can be written as is in profile p
+func (p EscapeProfile) isSafe(b byte) bool {
+	if p == EscapeProfileStrict {
+		return isNotReserved(b)
+	}
+	if b < 0x20 || b > 0x7e || b == '%' || b == '#' {
+		return false
+	}
+	return strings.IndexByte(p.unsafe(), b) < 0
+}
+
+// appendEscapedPayload is like appendEscaped, but also escapes a trailing
+// space, which URL parsers strip from the input
+func appendEscapedPayload(dst, data []byte, profile EscapeProfile) []byte {
+	if n := len(data); n > 0 && data[n-1] == ' ' {
+		dst = appendEscaped(dst, data[:n-1], profile)
+		return append(dst, `%20`...)
+	}
+	return appendEscaped(dst, data, profile)
+}
+
+// escapedLen returns the length of data after it has been escaped
+// by appendEscapedPayload
+func escapedLen(data []byte, profile EscapeProfile) int {
+	n := len(data)
+	for _, b := range data {
+		if !profile.isSafe(b) {
+			n += 2
+		}
+	}
+	if len(data) > 0 && data[len(data)-1] == ' ' && profile.isSafe(' ') {
+		n += 2
+	}
+	return n
+}
@@ -0,0 +1,33 @@
+package examples
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lestrrat-go/dataurl"
+)
+
+func ExampleNewDecoder() {
+	// Any io.Reader can be used, such as an HTTP request body or a file
+	src := strings.NewReader(`data:application/json;charset=utf-8;base64,eyJIZWxsbyI6IldvcmxkISJ9`)
+
+	dec := dataurl.NewDecoder(src)
+	mt, err := dec.MediaType()
+	if err != nil {
+		fmt.Printf("failed to read header: %s", err)
+		return
+	}
+	fmt.Printf("media type: %q\n", mt.Type)
+
+	// The payload is decoded as it is being copied
+	if _, err := io.Copy(os.Stdout, dec); err != nil {
+		fmt.Printf("failed to decode payload: %s", err)
+		return
+	}
+
+	// OUTPUT:
+	// media type: "application/json"
+	// {"Hello":"World!"}
+}
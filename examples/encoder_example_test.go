@@ -0,0 +1,31 @@
+package examples
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lestrrat-go/dataurl"
+)
+
+func ExampleNewEncoder() {
+	// Any io.Reader can be used as the source, such as a file
+	src := strings.NewReader(`{"Hello":"World!"}`)
+
+	// The data URL is written to os.Stdout as the data is being copied
+	enc := dataurl.NewEncoder(os.Stdout, dataurl.WithMediaType(`application/json`))
+	if _, err := io.Copy(enc, src); err != nil {
+		fmt.Printf("failed to encode: %s", err)
+		return
+	}
+
+	// Close must be called to write out the remaining data
+	if err := enc.Close(); err != nil {
+		fmt.Printf("failed to encode: %s", err)
+		return
+	}
+
+	// OUTPUT:
+	// data:application/json;base64,eyJIZWxsbyI6IldvcmxkISJ9
+}
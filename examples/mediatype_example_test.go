@@ -0,0 +1,28 @@
+package examples
+
+import (
+	"fmt"
+
+	"github.com/lestrrat-go/dataurl"
+)
+
+func ExampleParseMediaType() {
+	mt, err := dataurl.ParseMediaType(`Image/SVG+XML; charset=utf-8`)
+	if err != nil {
+		fmt.Printf("failed to parse: %s", err)
+		return
+	}
+
+	fmt.Printf("essence: %s\n", mt.Essence())
+	fmt.Printf("top: %s, sub: %s, suffix: %s\n", mt.Top(), mt.Sub(), mt.Suffix())
+	fmt.Printf("charset: %s\n", mt.OrderedParams().Get(`Charset`))
+	fmt.Printf("string: %s\n", mt)
+	fmt.Printf("is XML: %t\n", mt.Match(`*/*+xml`))
+
+	// OUTPUT:
+	// essence: image/svg+xml
+	// top: image, sub: svg+xml, suffix: +xml
+	// charset: utf-8
+	// string: image/svg+xml;charset=utf-8
+	// is XML: true
+}
@@ -0,0 +1,25 @@
+package examples
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lestrrat-go/dataurl"
+)
+
+func ExampleWithPolicy() {
+	policy := &dataurl.Policy{
+		Allow: []string{`image/*`},
+		Deny:  []string{`image/svg+xml`},
+	}
+
+	_, err := dataurl.Parse([]byte(`data:image/svg+xml;base64,PHN2Zy8+`), dataurl.WithPolicy(policy))
+
+	var violation *dataurl.PolicyViolation
+	if errors.As(err, &violation) {
+		fmt.Printf("rejected by rule %q\n", violation.Rule)
+	}
+
+	// OUTPUT:
+	// rejected by rule "image/svg+xml"
+}
@@ -0,0 +1,69 @@
+package dataurl
+
+import (
+	"mime"
+	"path"
+	"strings"
+)
+
+// extensionTypes maps file extensions to media types. It is consulted
+// before "mime".TypeByExtension, so that the media types of the formats
+// that are commonly inlined do not depend on the tables that are installed
+// on the system, such as /etc/mime.types.
+var extensionTypes = map[string]string{
+	`.apng`:        `image/apng`,
+	`.avif`:        `image/avif`,
+	`.bmp`:         `image/bmp`,
+	`.css`:         `text/css`,
+	`.csv`:         `text/csv`,
+	`.gif`:         `image/gif`,
+	`.heic`:        `image/heic`,
+	`.htm`:         `text/html`,
+	`.html`:        `text/html`,
+	`.ico`:         `image/x-icon`,
+	`.jpeg`:        `image/jpeg`,
+	`.jpg`:         `image/jpeg`,
+	`.js`:          `text/javascript`,
+	`.json`:        `application/json`,
+	`.jsonld`:      `application/ld+json`,
+	`.md`:          `text/markdown`,
+	`.mjs`:         `text/javascript`,
+	`.mp3`:         `audio/mpeg`,
+	`.mp4`:         `video/mp4`,
+	`.oga`:         `audio/ogg`,
+	`.ogg`:         `audio/ogg`,
+	`.ogv`:         `video/ogg`,
+	`.otf`:         `font/otf`,
+	`.pdf`:         `application/pdf`,
+	`.png`:         `image/png`,
+	`.svg`:         `image/svg+xml`,
+	`.tif`:         `image/tiff`,
+	`.tiff`:        `image/tiff`,
+	`.ttf`:         `font/ttf`,
+	`.txt`:         `text/plain`,
+	`.wasm`:        `application/wasm`,
+	`.wav`:         `audio/wav`,
+	`.weba`:        `audio/webm`,
+	`.webm`:        `video/webm`,
+	`.webmanifest`: `application/manifest+json`,
+	`.webp`:        `image/webp`,
+	`.woff`:        `font/woff`,
+	`.woff2`:       `font/woff2`,
+	`.xml`:         `application/xml`,
+}
+
+// typeByFilename returns the media type associated with the extension
+// of name, which may also be an extension such as ".css". Extensions
+// are matched case-insensitively. An empty string is returned if the
+// extension is unknown.
+func typeByFilename(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+
+	if mt, ok := extensionTypes[ext]; ok {
+		return mt
+	}
+	return mime.TypeByExtension(ext)
+}
@@ -0,0 +1,118 @@
+package dataurl
+
+import (
+	"fmt"
+	"io"
+)
+
+// This file implements the size limits that can be specified using
+// WithMaxEncodedLength, WithMaxDecodedLength, WithMaxHeaderLength and
+// WithMaxParams. A limit of zero or less means that there is no limit.
+//
+// All limits are checked before the corresponding memory is allocated,
+// so that untrusted input cannot make the parser allocate more than
+// what the limits allow.
+
+// checkEncodedLength checks the length of the entire input
+func (p *parser) checkEncodedLength(data []byte) error {
+	if p.maxEncoded > 0 && len(data) > p.maxEncoded {
+		return p.encodedTooLarge()
+	}
+	return nil
+}
+
+func (p *parser) encodedTooLarge() error {
+	return newParseError(KindTooLarge, p.maxEncoded, fmt.Errorf(`input exceeds the limit of %d bytes`, p.maxEncoded))
+}
+
+// checkHeader checks the length of header, which is the section of the
+// input between the scheme and the comma, and the number of parameters
+// in it. offset is the position of header within the original input.
+func (p *parser) checkHeader(header []byte, offset int) error {
+	if p.maxHeader > 0 && len(header) > p.maxHeader {
+		return newParseError(KindTooLarge, offset+p.maxHeader, fmt.Errorf(`header exceeds the limit of %d bytes`, p.maxHeader))
+	}
+
+	if p.maxParams > 0 {
+		mediaType, _ := splitBase64Marker(header, p.mode)
+		if n := countSeparators(mediaType); n > p.maxParams {
+			return newParseError(KindTooLarge, offset, fmt.Errorf(`%d parameters exceed the limit of %d parameters`, n, p.maxParams))
+		}
+	}
+	return nil
+}
+
+// checkDecodedLength checks the length of the payload after it has been
+// decoded. offset is the position of payload within the original input.
+func (p *parser) checkDecodedLength(payload []byte, offset int, isBase64 bool) error {
+	if p.maxDecoded <= 0 {
+		return nil
+	}
+	if n := decodedLen(payload, isBase64); n > p.maxDecoded {
+		return p.decodedTooLarge(offset)
+	}
+	return nil
+}
+
+func (p *parser) decodedTooLarge(offset int) error {
+	return newParseError(KindTooLarge, offset, fmt.Errorf(`decoded payload exceeds the limit of %d bytes`, p.maxDecoded))
+}
+
+// countSeparators returns the number of semicolons in header that are
+// not inside quoted strings, which is the number of parameters
+func countSeparators(header []byte) int {
+	var n int
+	for i := indexSeparator(header); i > -1; i = indexSeparator(header) {
+		header = header[i+1:]
+		n++
+	}
+	return n
+}
+
+// decodedLen returns the length of payload after it has been decoded,
+// without allocating any memory. Percent-escaped sequences count as a
+// single byte, and ASCII whitespace and padding are excluded from
+// base64 payloads. The result is exact for payloads that can be decoded.
+func decodedLen(payload []byte, isBase64 bool) int {
+	var n int
+	for i := 0; i < len(payload); i++ {
+		c := payload[i]
+		if c == '%' && i+2 < len(payload) && isHex(payload[i+1]) && isHex(payload[i+2]) {
+			c = unhex(payload[i+1])<<4 | unhex(payload[i+2])
+			i += 2
+		}
+		if isBase64 && (isSpace(c) || c == '=') {
+			continue
+		}
+		n++
+	}
+
+	if isBase64 {
+		return n * 6 / 8
+	}
+	return n
+}
+
+// limitReader reads from src until the encoded length limit is exceeded
+type limitReader struct {
+	src    io.Reader
+	parser *parser
+	n      int // number of bytes read so far
+}
+
+func (r *limitReader) Read(b []byte) (int, error) {
+	// read one byte more than allowed to find out if the input
+	// exceeds the limit
+	if remaining := r.parser.maxEncoded - r.n + 1; len(b) > remaining {
+		b = b[:remaining]
+	}
+
+	n, err := r.src.Read(b)
+	if r.n+n > r.parser.maxEncoded {
+		n = r.parser.maxEncoded - r.n
+		r.n = r.parser.maxEncoded
+		return n, r.parser.encodedTooLarge()
+	}
+	r.n += n
+	return n, err
+}
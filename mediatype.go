@@ -0,0 +1,509 @@
+package dataurl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MediaType holds the relevant type information for the data.
+type MediaType struct {
+	Type   string
+	Params map[string]string
+
+	// order holds the lower case parameter names in the order in which
+	// they appeared in the input. It is nil if the order is not known,
+	// or if it is the sorted order that OrderedParams uses otherwise.
+	order []string
+}
+
+// ParseMediaType parses s as a media type, such as `text/plain;charset=utf-8`.
+//
+// The type and the subtype must conform to the restricted-name grammar
+// from RFC 6838. Parameter names must be RFC 2045 tokens, and parameter
+// values must be either tokens or quoted strings. Whitespace is allowed
+// around the semicolons that separate the parameters, but not around the
+// equal signs. Parameters may not appear more than once.
+//
+// Extended parameter values as described in RFC 2231, such as
+// `title*=utf-8”%E2%82%AC`, are decoded if they use either the UTF-8
+// or the US-ASCII charset. Parameter continuations are not supported.
+//
+// The type and parameter names are converted to lower case. Errors
+// can be matched against ErrInvalidMediaType using `errors.Is()`.
+func ParseMediaType(s string) (MediaType, error) {
+	essence, rest := splitEssence(s)
+	i := strings.IndexByte(essence, '/')
+	if i < 0 {
+		return MediaType{}, fmt.Errorf(`%w %q: missing subtype`, ErrInvalidMediaType, s)
+	}
+	if !isRestrictedName(essence[:i]) {
+		return MediaType{}, fmt.Errorf(`%w %q: invalid type %q`, ErrInvalidMediaType, s, essence[:i])
+	}
+	if !isRestrictedName(essence[i+1:]) {
+		return MediaType{}, fmt.Errorf(`%w %q: invalid subtype %q`, ErrInvalidMediaType, s, essence[i+1:])
+	}
+
+	params, err := parseParams(s, rest)
+	if err != nil {
+		return MediaType{}, err
+	}
+	mt := MediaType{Type: strings.ToLower(essence), Params: params}
+	mt.recordOrder([]byte(rest), nil)
+	return mt, nil
+}
+
+// splitEssence splits s at the first semicolon into the type and the
+// subtype, with surrounding whitespace removed, and the parameters,
+// which start with the semicolon.
+func splitEssence(s string) (string, string) {
+	s = trimOWS(s)
+	if i := strings.IndexByte(s, ';'); i > -1 {
+		return trimOWS(s[:i]), s[i:]
+	}
+	return s, ``
+}
+
+// parseParams parses the parameters section of the media type s,
+// which must either be empty or start with a semicolon
+func parseParams(s, rest string) (map[string]string, error) {
+	params := map[string]string{}
+	for len(rest) > 0 {
+		// rest always starts with ';' here
+		rest = trimLeftOWS(rest[1:])
+		if len(rest) == 0 {
+			// a trailing semicolon is ignored
+			break
+		}
+
+		i := strings.IndexByte(rest, '=')
+		if i < 0 {
+			return nil, fmt.Errorf(`%w %q: invalid parameter %q`, ErrInvalidMediaType, s, rest)
+		}
+		name := rest[:i]
+		if !isToken([]byte(name)) {
+			return nil, fmt.Errorf(`%w %q: invalid parameter name %q`, ErrInvalidMediaType, s, name)
+		}
+		rest = rest[i+1:]
+
+		var value string
+		if len(rest) > 0 && rest[0] == '"' {
+			end := quotedStringEnd(rest)
+			if end < 0 {
+				return nil, fmt.Errorf(`%w %q: unterminated quoted string for parameter %q`, ErrInvalidMediaType, s, name)
+			}
+			unquoted, err := unquote([]byte(rest[:end]))
+			if err != nil {
+				return nil, fmt.Errorf(`%w %q: invalid value for parameter %q: %s`, ErrInvalidMediaType, s, name, err)
+			}
+			value, rest = string(unquoted), rest[end:]
+		} else {
+			end := strings.IndexAny(rest, "; \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = rest[:end], rest[end:]
+			if !isToken([]byte(value)) {
+				return nil, fmt.Errorf(`%w %q: invalid value for parameter %q`, ErrInvalidMediaType, s, name)
+			}
+		}
+
+		rest = trimLeftOWS(rest)
+		if len(rest) > 0 && rest[0] != ';' {
+			return nil, fmt.Errorf(`%w %q: unexpected %q after parameter %q`, ErrInvalidMediaType, s, rest, name)
+		}
+
+		if strings.HasSuffix(name, `*`) {
+			decoded, err := decodeRFC2231(value)
+			if err != nil {
+				return nil, fmt.Errorf(`%w %q: invalid value for parameter %q: %s`, ErrInvalidMediaType, s, name, err)
+			}
+			name, value = name[:len(name)-1], decoded
+		}
+		name = strings.ToLower(name)
+		if _, ok := params[name]; ok {
+			return nil, fmt.Errorf(`%w %q: duplicate parameter %q`, ErrInvalidMediaType, s, name)
+		}
+		params[name] = value
+	}
+	return params, nil
+}
+
+// String returns the media type in the form that is used in data URLs,
+// that is, without any whitespace between the parameters. Parameters
+// are written in the order of OrderedParams. Values that are not tokens
+// are quoted, and values that contain non-ASCII characters are encoded
+// as described in RFC 2231. Data URLs additionally percent-escape the
+// parameters, which String does not.
+//
+// If the type, the subtype or any of the parameter names is invalid,
+// an empty string is returned.
+func (mt MediaType) String() string {
+	buf, err := mt.append(nil, false)
+	if err != nil {
+		return ``
+	}
+	return string(buf)
+}
+
+// append appends the string representation of mt to dst. If escape is
+// true, the parameters are percent-escaped as they are in data URLs,
+// rather than quoted, which allows any parameter name that is not empty.
+// If mt cannot be formatted, dst is returned as is along with an error
+// that matches ErrInvalidMediaType.
+func (mt MediaType) append(dst []byte, escape bool) ([]byte, error) {
+	i := strings.IndexByte(mt.Type, '/')
+	if i < 0 || !isRestrictedName(mt.Type[:i]) || !isRestrictedName(mt.Type[i+1:]) {
+		return dst, fmt.Errorf(`%w %q`, ErrInvalidMediaType, mt.Type)
+	}
+	for name := range mt.Params {
+		if name == "" || (!escape && !isToken([]byte(name))) {
+			return dst, fmt.Errorf(`%w %q: invalid parameter name %q`, ErrInvalidMediaType, mt.Type, name)
+		}
+	}
+
+	dst = appendLower(dst, mt.Type)
+	for _, v := range mt.OrderedParams().list {
+		dst = append(dst, ';')
+		switch {
+		case escape:
+			dst = appendParamEscaped(dst, v.name)
+			dst = append(dst, '=')
+			if v.value == "" {
+				dst = append(dst, '"', '"')
+			}
+			dst = appendParamEscaped(dst, v.value)
+		case needsRFC2231(v.value):
+			dst = appendLower(dst, v.name)
+			dst = append(dst, "*=utf-8''"...)
+			for j := 0; j < len(v.value); j++ {
+				c := v.value[j]
+				if isTokenChar(c) && c != '*' && c != '\'' && c != '%' {
+					dst = append(dst, c)
+					continue
+				}
+				dst = append(dst, '%', upperHex[c>>4], upperHex[c&0x0f])
+			}
+		case isToken([]byte(v.value)):
+			dst = appendLower(dst, v.name)
+			dst = append(dst, '=')
+			dst = append(dst, v.value...)
+		default:
+			dst = appendLower(dst, v.name)
+			dst = append(dst, '=', '"')
+			for j := 0; j < len(v.value); j++ {
+				if c := v.value[j]; c == '"' || c == '\\' {
+					dst = append(dst, '\\')
+				}
+				dst = append(dst, v.value[j])
+			}
+			dst = append(dst, '"')
+		}
+	}
+	return dst, nil
+}
+
+// appendParamEscaped appends the name or the value of a parameter to
+// dst as it is written in data URLs. Every byte that is not both a token
+// character and a URL character is percent-escaped, so that the result
+// is a token that Parse unescapes back to s. '*' is escaped as well, as
+// it would otherwise mark RFC 2231 parameter names.
+func appendParamEscaped(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isTokenChar(c) && isURLChar(c) && c != '*' {
+			dst = append(dst, c)
+			continue
+		}
+		dst = append(dst, '%', upperHex[c>>4], upperHex[c&0x0f])
+	}
+	return dst
+}
+
+// Essence returns the type and the subtype in lower case, without
+// any parameters, such as `image/svg+xml`.
+func (mt MediaType) Essence() string {
+	return strings.ToLower(mt.Type)
+}
+
+// Top returns the top-level type, such as `image` for `image/svg+xml`.
+func (mt MediaType) Top() string {
+	essence := mt.Essence()
+	if i := strings.IndexByte(essence, '/'); i > -1 {
+		return essence[:i]
+	}
+	return essence
+}
+
+// Sub returns the subtype, such as `svg+xml` for `image/svg+xml`.
+// An empty string is returned if there is no subtype.
+func (mt MediaType) Sub() string {
+	essence := mt.Essence()
+	if i := strings.IndexByte(essence, '/'); i > -1 {
+		return essence[i+1:]
+	}
+	return ``
+}
+
+// Suffix returns the structured syntax suffix of the subtype including
+// the plus sign, such as `+xml` for `image/svg+xml`. An empty string
+// is returned if the subtype does not have a suffix.
+func (mt MediaType) Suffix() string {
+	sub := mt.Sub()
+	if i := strings.LastIndexByte(sub, '+'); i > -1 {
+		return sub[i:]
+	}
+	return ``
+}
+
+// Match reports whether mt matches pattern, which is a media type that
+// may contain wildcards. Types and subtypes are compared case-insensitively.
+//
+// The following patterns are supported:
+//
+//   - `*/*` (or `*`) matches any media type
+//   - `image/*` matches any subtype of `image`
+//   - `application/*+json` matches any subtype of `application` whose
+//     structured syntax suffix is `+json`, such as `application/ld+json`.
+//     `*/*+json` matches such subtypes of any type.
+//   - `text/plain` matches `text/plain` only
+//
+// If the pattern has parameters, mt must have the same parameters with
+// the same values. The values of the charset parameter are compared
+// case-insensitively. Parameters that are not in the pattern are not
+// considered. For example, `text/*;charset=utf-8` matches
+// `text/html;charset=UTF-8;level=1` but not `text/html`.
+//
+// Invalid patterns never match.
+func (mt MediaType) Match(pattern string) bool {
+	essence, rest := splitEssence(pattern)
+	params, err := parseParams(pattern, rest)
+	if err != nil {
+		return false
+	}
+
+	top, sub := `*`, `*`
+	if essence != `*` {
+		i := strings.IndexByte(essence, '/')
+		if i < 0 {
+			return false
+		}
+		top, sub = strings.ToLower(essence[:i]), strings.ToLower(essence[i+1:])
+	}
+
+	if mt.Sub() == `` {
+		return false
+	}
+
+	switch {
+	case top == `*`:
+		if sub != `*` && !strings.HasPrefix(sub, `*+`) {
+			// `*/plain` is not a valid pattern
+			return false
+		}
+	case !isRestrictedName(top) || top != mt.Top():
+		return false
+	}
+
+	switch {
+	case sub == `*`:
+	case strings.HasPrefix(sub, `*+`):
+		if !isRestrictedName(sub[2:]) || sub[1:] != mt.Suffix() {
+			return false
+		}
+	case !isRestrictedName(sub) || sub != mt.Sub():
+		return false
+	}
+
+	for name, want := range params {
+		value, ok := lookupParam(mt.Params, name)
+		if !ok {
+			return false
+		}
+		if name == `charset` {
+			if !strings.EqualFold(value, want) {
+				return false
+			}
+		} else if value != want {
+			return false
+		}
+	}
+	return true
+}
+
+// OrderedParams returns the parameters of mt as Params, which can be
+// accessed case-insensitively. If mt was obtained by parsing, the
+// parameters are in the order in which they appeared in the input.
+// Parameters that were added to mt.Params afterwards, and the parameters
+// of media types from other sources, follow in sorted order.
+//
+// The result is a copy: use SetOrderedParams to store it back in mt.
+func (mt MediaType) OrderedParams() Params {
+	var p Params
+	for _, name := range mt.order {
+		if value, ok := mt.Params[name]; ok {
+			p.Set(name, value)
+		}
+	}
+
+	if p.Len() < len(mt.Params) {
+		rest := make([]string, 0, len(mt.Params)-p.Len())
+		for name := range mt.Params {
+			if p.index(name) < 0 {
+				rest = append(rest, name)
+			}
+		}
+		sort.Strings(rest)
+		for _, name := range rest {
+			p.Set(name, mt.Params[name])
+		}
+	}
+	return p
+}
+
+// SetOrderedParams replaces the parameters of mt with p. mt.Params is
+// set to p.Map(), and OrderedParams returns the parameters in the order
+// of p, which is also the order in which they are formatted.
+func (mt *MediaType) SetOrderedParams(p Params) {
+	mt.Params = p.Map()
+	mt.order = p.Names()
+	mt.trimOrder()
+}
+
+// recordOrder records the order in which the parameters of mt appear
+// in header. If unescape is not nil, it is applied to the names in
+// header before they are compared to the names in mt.Params.
+func (mt *MediaType) recordOrder(header []byte, unescape func([]byte) []byte) {
+	mt.order = nil
+	if len(mt.Params) < 2 {
+		return
+	}
+	for _, name := range paramOrder(header, unescape) {
+		if _, ok := mt.Params[name]; ok && !containsString(mt.order, name) {
+			mt.order = append(mt.order, name)
+		}
+	}
+	mt.trimOrder()
+}
+
+// trimOrder forgets the order of the parameters if it is the same as
+// the sorted order, so that media types with the same parameters in
+// the same order compare equal using reflect.DeepEqual
+func (mt *MediaType) trimOrder() {
+	if len(mt.order) == len(mt.Params) && sort.StringsAreSorted(mt.order) {
+		mt.order = nil
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupParam returns the value of the parameter name in params. Names
+// are compared case-insensitively.
+func lookupParam(params map[string]string, name string) (string, bool) {
+	if value, ok := params[name]; ok {
+		return value, true
+	}
+	for k, value := range params {
+		if strings.EqualFold(k, name) {
+			return value, true
+		}
+	}
+	return ``, false
+}
+
+// isRestrictedName returns true if s conforms to the restricted-name
+// grammar from RFC 6838, which is used for types and subtypes
+func isRestrictedName(s string) bool {
+	if len(s) == 0 || len(s) > 127 || !isAlnum(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if c := s[i]; !isAlnum(c) && strings.IndexByte("!#$&-^_.+", c) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlnum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// needsRFC2231 returns true if s contains characters that cannot be
+// represented in a quoted string
+func needsRFC2231(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < ' ' || c > '~') && c != '\t' {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeRFC2231 decodes an extended parameter value, which has the form
+// charset'language'percent-encoded-value
+func decodeRFC2231(s string) (string, error) {
+	parts := strings.SplitN(s, `'`, 3)
+	if len(parts) != 3 {
+		return ``, fmt.Errorf(`malformed extended value %q`, s)
+	}
+	if !strings.EqualFold(parts[0], `utf-8`) && !strings.EqualFold(parts[0], `us-ascii`) {
+		return ``, fmt.Errorf(`unsupported charset %q`, parts[0])
+	}
+	decoded, err := unescape([]byte(parts[2]), 0, nil)
+	if err != nil {
+		return ``, fmt.Errorf(`malformed extended value %q`, s)
+	}
+	return string(decoded), nil
+}
+
+// quotedStringEnd returns the position immediately after the quoted
+// string that s starts with, or -1 if it is not terminated
+func quotedStringEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func appendLower(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+func isOWS(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+func trimLeftOWS(s string) string {
+	for len(s) > 0 && isOWS(s[0]) {
+		s = s[1:]
+	}
+	return s
+}
+
+func trimOWS(s string) string {
+	s = trimLeftOWS(s)
+	for len(s) > 0 && isOWS(s[len(s)-1]) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
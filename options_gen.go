@@ -20,14 +20,151 @@ type encodeOption struct {
 
 func (*encodeOption) encodeOption() {}
 
+// EncodeParseOption is a type of option that can be passed to
+// either Encode() or Parse()
+type EncodeParseOption interface {
+	EncodeOption
+	ParseOption
+	encodeOption()
+	parseOption()
+}
+
+type encodeParseOption struct {
+	Option
+}
+
+func (*encodeParseOption) encodeOption() {}
+
+func (*encodeParseOption) parseOption() {}
+
+// EncodeParseVerifyOption is a type of option that can be passed to
+// Encode(), Parse() or (*URL).Verify()
+type EncodeParseVerifyOption interface {
+	EncodeOption
+	ParseOption
+	VerifyOption
+	encodeOption()
+	parseOption()
+	verifyOption()
+}
+
+type encodeParseVerifyOption struct {
+	Option
+}
+
+func (*encodeParseVerifyOption) encodeOption() {}
+
+func (*encodeParseVerifyOption) parseOption() {}
+
+func (*encodeParseVerifyOption) verifyOption() {}
+
+// ParseOption is a type of option that can be passed to Parse()
+type ParseOption interface {
+	Option
+	parseOption()
+}
+
+type parseOption struct {
+	Option
+}
+
+func (*parseOption) parseOption() {}
+
+// ParseVerifyOption is a type of option that can be passed to
+// either Parse() or (*URL).Verify()
+type ParseVerifyOption interface {
+	ParseOption
+	VerifyOption
+	parseOption()
+	verifyOption()
+}
+
+type parseVerifyOption struct {
+	Option
+}
+
+func (*parseVerifyOption) parseOption() {}
+
+func (*parseVerifyOption) verifyOption() {}
+
+// VerifyOption is a type of option that can be passed to (*URL).Verify()
+type VerifyOption interface {
+	Option
+	verifyOption()
+}
+
+type verifyOption struct {
+	Option
+}
+
+func (*verifyOption) verifyOption() {}
+
 type identBase64Encoding struct{}
+type identBase64Variant struct{}
+type identCharset struct{}
+type identEncodingStrategy struct{}
+type identEscapeProfile struct{}
+type identFilename struct{}
+type identIgnoreWhitespace struct{}
+type identMaxDecodedLength struct{}
+type identMaxEncodedLength struct{}
+type identMaxHeaderLength struct{}
+type identMaxParams struct{}
 type identMediaType struct{}
 type identMediaTypeParams struct{}
+type identOrderedParams struct{}
+type identParseMode struct{}
+type identPolicy struct{}
+type identPreserveInput struct{}
+type identSniffer struct{}
+type identStrict struct{}
+type identTolerances struct{}
+type identVerify struct{}
 
 func (identBase64Encoding) String() string {
 	return "WithBase64Encoding"
 }
 
+func (identBase64Variant) String() string {
+	return "WithBase64Variant"
+}
+
+func (identCharset) String() string {
+	return "WithCharset"
+}
+
+func (identEncodingStrategy) String() string {
+	return "WithEncodingStrategy"
+}
+
+func (identEscapeProfile) String() string {
+	return "WithEscapeProfile"
+}
+
+func (identFilename) String() string {
+	return "WithFilename"
+}
+
+func (identIgnoreWhitespace) String() string {
+	return "WithIgnoreWhitespace"
+}
+
+func (identMaxDecodedLength) String() string {
+	return "WithMaxDecodedLength"
+}
+
+func (identMaxEncodedLength) String() string {
+	return "WithMaxEncodedLength"
+}
+
+func (identMaxHeaderLength) String() string {
+	return "WithMaxHeaderLength"
+}
+
+func (identMaxParams) String() string {
+	return "WithMaxParams"
+}
+
 func (identMediaType) String() string {
 	return "WithMediaType"
 }
@@ -36,6 +173,38 @@ func (identMediaTypeParams) String() string {
 	return "WithMediaTypeParams"
 }
 
+func (identOrderedParams) String() string {
+	return "WithOrderedParams"
+}
+
+func (identParseMode) String() string {
+	return "WithParseMode"
+}
+
+func (identPolicy) String() string {
+	return "WithPolicy"
+}
+
+func (identPreserveInput) String() string {
+	return "WithPreserveInput"
+}
+
+func (identSniffer) String() string {
+	return "WithSniffer"
+}
+
+func (identStrict) String() string {
+	return "WithStrict"
+}
+
+func (identTolerances) String() string {
+	return "WithTolerances"
+}
+
+func (identVerify) String() string {
+	return "WithVerify"
+}
+
 // WithBase64Encoding specifies if the payload should or should not
 // be base64 encoded. Specifying this option overrides the automatic
 // detection that is performed by default, where any payload without
@@ -44,11 +213,135 @@ func WithBase64Encoding(v bool) EncodeOption {
 	return &encodeOption{option.New(identBase64Encoding{}, v)}
 }
 
+// WithBase64Variant specifies the base64 alphabet and padding to use.
+//
+// When passed to `dataurl.Encode()`, the payload is encoded using
+// the given variant. `dataurl.Base64AutoDetect` is treated the same as
+// `dataurl.Base64Standard`.
+//
+// When passed to `dataurl.Parse()`, base64 payloads are decoded
+// using the given variant. If `dataurl.Base64AutoDetect` is specified,
+// the variant is guessed from the alphabet and the presence of
+// padding in the payload. This option has no effect when parsing with
+// `dataurl.ParseModeWHATWG`, as the algorithm specifies its own
+// base64 decoding rules.
+//
+// By default the standard alphabet with padding is used, which is
+// `dataurl.Base64Standard`.
+func WithBase64Variant(v Base64Variant) EncodeParseOption {
+	return &encodeParseOption{option.New(identBase64Variant{}, v)}
+}
+
+// WithCharset specifies the character encoding of the data URL. The
+// data, which must be UTF-8 text, is transcoded into it, and the
+// `charset` parameter is set to the given name, overriding any
+// `charset` parameter from other sources.
+//
+// The same character encodings as `(*dataurl.URL).Text()` are
+// supported. If a character cannot be represented in the character
+// encoding, an error that wraps a `*dataurl.UnrepresentableCharacter`
+// is returned.
+func WithCharset(v string) EncodeOption {
+	return &encodeOption{option.New(identCharset{}, v)}
+}
+
+// WithEncodingStrategy specifies how the payload is encoded when
+// `dataurl.WithBase64Encoding()` is not specified.
+//
+// By default `dataurl.EncodingStrategyMediaType` is used, which only
+// percent-encodes `text/****` payloads. Use
+// `dataurl.EncodingStrategySmallest` to use whichever encoding produces
+// the shorter data URL, which is often percent-encoding for SVG and
+// JSON, and base64 for text that contains many non-ASCII characters.
+func WithEncodingStrategy(v EncodingStrategy) EncodeOption {
+	return &encodeOption{option.New(identEncodingStrategy{}, v)}
+}
+
+// WithEscapeProfile specifies which bytes are percent-escaped when
+// the payload is not base64 encoded, depending on where the data URL
+// is going to be embedded. See the documentation for
+// `dataurl.EscapeProfile` for the list of available profiles.
+//
+// By default `dataurl.EscapeProfileStrict` is used, which escapes
+// everything except the unreserved characters of RFC 2396.
+func WithEscapeProfile(v EscapeProfile) EncodeOption {
+	return &encodeOption{option.New(identEscapeProfile{}, v)}
+}
+
+// WithFilename specifies the name of the file that the data to be
+// encoded was read from, such as `style.css`. The media type is derived
+// from its extension, which is usually more reliable than sniffing
+// for formats such as CSS, JavaScript, SVG and JSON. A bare extension
+// such as `.css` may also be given.
+//
+// Extensions are looked up in a built-in table of formats that are
+// common on the web, and then using `"mime".TypeByExtension`.
+// `dataurl.WithMediaType()` takes precedence over this option, and
+// the media type is sniffed if the extension is unknown.
+func WithFilename(v string) EncodeOption {
+	return &encodeOption{option.New(identFilename{}, v)}
+}
+
+// WithIgnoreWhitespace specifies if ASCII whitespace (spaces, tabs,
+// line breaks and form feeds) inside base64 payloads should be
+// ignored. This allows parsing data URLs that were copied from
+// CSS files, email bodies and other sources that wrap long lines.
+//
+// This option is enabled by default when parsing with
+// `dataurl.ParseModeLenient`, and disabled otherwise. It has no effect
+// when parsing with `dataurl.ParseModeWHATWG`, as the algorithm
+// always ignores whitespace in base64 payloads.
+func WithIgnoreWhitespace(v bool) ParseOption {
+	return &parseOption{option.New(identIgnoreWhitespace{}, v)}
+}
+
+// WithMaxDecodedLength specifies the maximum length of the payload
+// after it has been decoded, in bytes. If the payload is longer, an
+// error that matches `dataurl.ErrTooLarge` is returned before any
+// memory is allocated for it.
+//
+// By default there is no limit.
+func WithMaxDecodedLength(v int) ParseOption {
+	return &parseOption{option.New(identMaxDecodedLength{}, v)}
+}
+
+// WithMaxEncodedLength specifies the maximum length of the entire
+// data URL, in bytes. If the input is longer, an error that
+// matches `dataurl.ErrTooLarge` is returned before it is parsed.
+// `dataurl.NewDecoder()` stops reading from its source as soon as
+// the limit is exceeded.
+//
+// By default there is no limit.
+func WithMaxEncodedLength(v int) ParseOption {
+	return &parseOption{option.New(identMaxEncodedLength{}, v)}
+}
+
+// WithMaxHeaderLength specifies the maximum length of the section
+// between the scheme and the comma, which contains the media type,
+// its parameters and the `;base64` marker, in bytes. If the header
+// is longer, an error that matches `dataurl.ErrTooLarge` is returned
+// before it is parsed.
+//
+// By default there is no limit.
+func WithMaxHeaderLength(v int) ParseOption {
+	return &parseOption{option.New(identMaxHeaderLength{}, v)}
+}
+
+// WithMaxParams specifies the maximum number of media type parameters.
+// If there are more parameters, an error that matches `dataurl.ErrTooLarge`
+// is returned before they are parsed.
+//
+// By default there is no limit.
+func WithMaxParams(v int) ParseOption {
+	return &parseOption{option.New(identMaxParams{}, v)}
+}
+
 // WithMediaType allows users to specify an explciit media type for the
 // data to be encoded.
 //
-// If unspecified, `"net/http".DetectContentType` will be used to sniff
-// the media type.
+// If unspecified, the media type is derived from the filename specified
+// using `dataurl.WithFilename()`, or else sniffed using
+// `"net/http".DetectContentType`.
 //
 // You may include parameters (e.g. `charset=utf-8`) in this string as well,
 // but it is the caller's responsibility to make sure that it is well-formed.
@@ -63,8 +356,112 @@ func WithMediaType(v string) EncodeOption {
 // values found in the media type string provided either explcitly by the
 // user or by auto-detection.
 //
-// It is the user's reponsibility to properly format the parameter names,
-// such as properly making everything lower-case (or not).
+// Parameter names are case-insensitive, and are written in lower case.
+// Values may contain any bytes: they are percent-escaped as needed,
+// and `dataurl.Parse()` returns them as given.
 func WithMediaTypeParams(v map[string]string) EncodeOption {
 	return &encodeOption{option.New(identMediaTypeParams{}, v)}
 }
+
+// WithOrderedParams is like `dataurl.WithMediaTypeParams()`, but takes
+// the parameters as Params, and keeps their order. Parameters that are
+// not in the media type string are written after the ones that are,
+// in the order of the given Params.
+//
+// Upon any conflict, values provided here overwrite the values given
+// using `dataurl.WithMediaTypeParams()`.
+func WithOrderedParams(v Params) EncodeOption {
+	return &encodeOption{option.New(identOrderedParams{}, v)}
+}
+
+// WithParseMode specifies the algorithm that `dataurl.Parse()` uses to
+// interpret its input. See the documentation for `dataurl.ParseMode`
+// for the list of available modes.
+//
+// If this option is not specified, `dataurl.ParseModeDefault` is used.
+func WithParseMode(v ParseMode) ParseOption {
+	return &parseOption{option.New(identParseMode{}, v)}
+}
+
+// WithPolicy specifies the media types that are accepted. If the media
+// type of the data URL is not accepted by the policy, an error
+// that matches `dataurl.ErrPolicyViolation` is returned before the
+// payload is decoded. Use `errors.As()` to obtain the
+// `*dataurl.PolicyViolation` that describes which rule was violated.
+//
+// This option is useful for rejecting media types that are dangerous
+// to render, such as `text/html` and `image/svg+xml`.
+func WithPolicy(v *Policy) ParseOption {
+	return &parseOption{option.New(identPolicy{}, v)}
+}
+
+// WithPreserveInput specifies if the URL returned by `dataurl.Parse()`
+// should remember its original input. If enabled,
+// `(*dataurl.URL).String()` and `MarshalText()` reproduce the input
+// byte for byte unless the URL has been modified, and `URL.Header` is
+// set.
+//
+// This keeps a copy of the input and of the decoded data for as long
+// as the URL is in use, so it is disabled by default.
+func WithPreserveInput(v bool) ParseOption {
+	return &parseOption{option.New(identPreserveInput{}, v)}
+}
+
+// WithSniffer specifies the Sniffer that detects the media type of
+// the data.
+//
+// When passed to `dataurl.Encode()`, it is used if the media type
+// is not specified using `dataurl.WithMediaType()`. When passed to
+// `(*dataurl.URL).Verify()`, or to `dataurl.Parse()` along with
+// `dataurl.WithVerify(true)`, it is used to detect the media type
+// that is compared with the declared media type.
+//
+// By default `dataurl.HTTPSniffer` is used. `dataurl.WebSniffer`
+// recognizes more formats that are common on the web.
+func WithSniffer(v Sniffer) EncodeParseVerifyOption {
+	return &encodeParseVerifyOption{option.New(identSniffer{}, v)}
+}
+
+// WithStrict specifies how strictly `dataurl.Parse()` should interpret
+// its input.
+//
+// When true, only data URLs that conform exactly to the RFC 2397 grammar
+// are accepted: the media type and its parameters must be valid tokens,
+// and the data section may only contain URL characters (RFC 2396)
+// or valid percent-escaped sequences.
+//
+// When false, the parser accepts what web browsers typically accept:
+// surrounding whitespace is ignored, the `;base64` marker is matched
+// case-insensitively, malformed media types fall back to
+// `text/plain;charset=US-ASCII`, and any byte may appear in the
+// data section.
+//
+// If this option is not specified, the historical behavior of this
+// library is used, which is neither fully strict nor fully lenient.
+//
+// This is a shorthand for `dataurl.WithParseMode(dataurl.ParseModeStrict)`
+// and `dataurl.WithParseMode(dataurl.ParseModeLenient)`, respectively.
+func WithStrict(v bool) ParseOption {
+	return &parseOption{option.New(identStrict{}, v)}
+}
+
+// WithTolerances specifies the combinations of declared and sniffed
+// media types that are accepted when verifying the content of a URL,
+// replacing the default table returned by `dataurl.DefaultTolerances()`.
+// Pass an empty slice to only accept exact matches.
+func WithTolerances(v []Tolerance) ParseVerifyOption {
+	return &parseVerifyOption{option.New(identTolerances{}, v)}
+}
+
+// WithVerify specifies if `dataurl.Parse()` should verify that the
+// media type detected from the decoded data matches the declared
+// media type, as `(*dataurl.URL).Verify()` does. If they do not match,
+// an error that matches `dataurl.ErrMediaTypeMismatch` is returned.
+// Use `errors.As()` to obtain the `*dataurl.MediaTypeMismatch` that
+// holds both media types.
+//
+// This option has no effect on `dataurl.AppendDecode()` and
+// `dataurl.NewDecoder()`, which do not hold the entire data.
+func WithVerify(v bool) ParseOption {
+	return &parseOption{option.New(identVerify{}, v)}
+}
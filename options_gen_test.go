@@ -10,6 +10,24 @@ import (
 
 func TestOptionIdent(t *testing.T) {
 	require.Equal(t, "WithBase64Encoding", identBase64Encoding{}.String())
+	require.Equal(t, "WithBase64Variant", identBase64Variant{}.String())
+	require.Equal(t, "WithCharset", identCharset{}.String())
+	require.Equal(t, "WithEncodingStrategy", identEncodingStrategy{}.String())
+	require.Equal(t, "WithEscapeProfile", identEscapeProfile{}.String())
+	require.Equal(t, "WithFilename", identFilename{}.String())
+	require.Equal(t, "WithIgnoreWhitespace", identIgnoreWhitespace{}.String())
+	require.Equal(t, "WithMaxDecodedLength", identMaxDecodedLength{}.String())
+	require.Equal(t, "WithMaxEncodedLength", identMaxEncodedLength{}.String())
+	require.Equal(t, "WithMaxHeaderLength", identMaxHeaderLength{}.String())
+	require.Equal(t, "WithMaxParams", identMaxParams{}.String())
 	require.Equal(t, "WithMediaType", identMediaType{}.String())
 	require.Equal(t, "WithMediaTypeParams", identMediaTypeParams{}.String())
+	require.Equal(t, "WithOrderedParams", identOrderedParams{}.String())
+	require.Equal(t, "WithParseMode", identParseMode{}.String())
+	require.Equal(t, "WithPolicy", identPolicy{}.String())
+	require.Equal(t, "WithPreserveInput", identPreserveInput{}.String())
+	require.Equal(t, "WithSniffer", identSniffer{}.String())
+	require.Equal(t, "WithStrict", identStrict{}.String())
+	require.Equal(t, "WithTolerances", identTolerances{}.String())
+	require.Equal(t, "WithVerify", identVerify{}.String())
 }
@@ -0,0 +1,144 @@
+package dataurl
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// Params holds media type parameters. Parameter names are case-insensitive,
+// and are stored in lower case. The parameters are kept in the order in
+// which they were added. Use MediaType.OrderedParams to obtain the
+// parameters of a media type as Params.
+//
+// The zero value is an empty set of parameters, ready to use.
+type Params struct {
+	list []param
+}
+
+type param struct {
+	name  string
+	value string
+}
+
+// NewParams creates a Params from m. As maps are not ordered, the
+// parameters are stored in sorted order.
+func NewParams(m map[string]string) Params {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var p Params
+	for _, name := range names {
+		p.Set(name, m[name])
+	}
+	return p
+}
+
+func (p Params) index(name string) int {
+	for i, v := range p.list {
+		if strings.EqualFold(v.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the value of the parameter name, or an empty string if
+// there is no such parameter.
+func (p Params) Get(name string) string {
+	v, _ := p.Lookup(name)
+	return v
+}
+
+// Lookup returns the value of the parameter name. The second return
+// value is false if there is no such parameter.
+func (p Params) Lookup(name string) (string, bool) {
+	i := p.index(name)
+	if i < 0 {
+		return "", false
+	}
+	return p.list[i].value, true
+}
+
+// Set sets the value of the parameter name. If the parameter already
+// exists, its value is replaced and its position is kept. Otherwise
+// the parameter is added at the end.
+func (p *Params) Set(name, value string) {
+	if i := p.index(name); i > -1 {
+		p.list[i].value = value
+		return
+	}
+	p.list = append(p.list, param{name: strings.ToLower(name), value: value})
+}
+
+// Del removes the parameter name, if it exists.
+func (p *Params) Del(name string) {
+	i := p.index(name)
+	if i < 0 {
+		return
+	}
+	list := make([]param, 0, len(p.list)-1)
+	list = append(list, p.list[:i]...)
+	p.list = append(list, p.list[i+1:]...)
+}
+
+// Len returns the number of parameters.
+func (p Params) Len() int {
+	return len(p.list)
+}
+
+// Names returns the names of the parameters in order.
+func (p Params) Names() []string {
+	names := make([]string, len(p.list))
+	for i, v := range p.list {
+		names[i] = v.name
+	}
+	return names
+}
+
+// Map returns the parameters as a map, keyed by the lower case parameter
+// names. This is the same form in which "mime".ParseMediaType returns
+// parameters. The map is a copy: modifying it does not affect p.
+func (p Params) Map() map[string]string {
+	m := make(map[string]string, len(p.list))
+	for _, v := range p.list {
+		m[v.name] = v.value
+	}
+	return m
+}
+
+// paramOrder returns the lower case names of the parameters in header,
+// in the order in which they appear. If unescape is not nil, it is
+// applied to the names. Tokens that are not parameters, such as the
+// media type itself, are included as well, and are expected to be
+// ignored by the caller.
+func paramOrder(header []byte, unescape func([]byte) []byte) []string {
+	var names []string
+	for {
+		token := header
+		i := indexSeparator(header)
+		if i > -1 {
+			token, header = header[:i], header[i+1:]
+		}
+
+		if j := bytes.IndexByte(token, '='); j > -1 {
+			token = token[:j]
+		}
+		// RFC 2231 continuations are merged into a single parameter
+		if j := bytes.IndexByte(token, '*'); j > -1 {
+			token = token[:j]
+		}
+		name := trimSpace(token)
+		if unescape != nil {
+			name = unescape(name)
+		}
+		names = append(names, strings.ToLower(string(name)))
+
+		if i < 0 {
+			return names
+		}
+	}
+}
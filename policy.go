@@ -0,0 +1,92 @@
+package dataurl
+
+import (
+	"fmt"
+)
+
+// Policy restricts the media types that are accepted by Parse and the
+// other functions that take ParseOptions. Use `dataurl.WithPolicy()`
+// to enforce a Policy.
+//
+// Patterns are matched using MediaType.Match, so wildcards such as
+// `image/*` and `application/*+json` may be used.
+//
+// In the lenient mode, media types that cannot be parsed fall back to
+// the default media type. A Policy is then also enforced on the media
+// type that web browsers see, as in the WHATWG mode.
+type Policy struct {
+	// Allow lists the patterns of the media types that are accepted.
+	// If empty, any media type that is not denied is accepted.
+	Allow []string
+	// Deny lists the patterns of the media types that are rejected.
+	// Deny takes precedence over Allow.
+	Deny []string
+}
+
+// PolicyViolation is the error that describes why a media type was
+// rejected by a Policy. When returned from Parse, it is wrapped in a
+// *ParseError whose Kind is KindPolicyViolation.
+type PolicyViolation struct {
+	// MediaType is the media type that was rejected
+	MediaType MediaType
+	// Rule is the pattern in Policy.Deny that matched the media type.
+	// It is empty if the media type was rejected because it did not
+	// match any of the patterns in Policy.Allow.
+	Rule string
+}
+
+func (e *PolicyViolation) Error() string {
+	if e.Rule == "" {
+		return fmt.Sprintf(`media type %q is not allowed`, e.MediaType.Essence())
+	}
+	return fmt.Sprintf(`media type %q is denied by rule %q`, e.MediaType.Essence(), e.Rule)
+}
+
+// Check returns a *PolicyViolation if mt is not accepted by p.
+// A nil Policy accepts any media type.
+func (p *Policy) Check(mt MediaType) error {
+	if p == nil {
+		return nil
+	}
+
+	for _, pattern := range p.Deny {
+		if mt.Match(pattern) {
+			return &PolicyViolation{MediaType: mt, Rule: pattern}
+		}
+	}
+
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range p.Allow {
+		if mt.Match(pattern) {
+			return nil
+		}
+	}
+	return &PolicyViolation{MediaType: mt}
+}
+
+// checkPolicy enforces the policy given to the parser, if any. mt is
+// the media type that was parsed from header, and offset is the position
+// of header within the original input.
+//
+// In the lenient mode, a header that cannot be parsed falls back to the
+// default media type, while web browsers may still find a media type in
+// it, such as text/html in `text/html;x`. The policy is therefore also
+// enforced on the media type that web browsers see.
+func (p *parser) checkPolicy(mt MediaType, header []byte, offset int) error {
+	if p.policy == nil {
+		return nil
+	}
+	if err := p.policy.Check(mt); err != nil {
+		return newParseError(KindPolicyViolation, offset, err)
+	}
+
+	if p.mode == ParseModeLenient {
+		mimeType, _ := splitWHATWGHeader(serializeWHATWG(header, true))
+		if err := p.policy.Check(whatwgMediaType(mimeType)); err != nil {
+			return newParseError(KindPolicyViolation, offset, err)
+		}
+	}
+	return nil
+}
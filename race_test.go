@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package dataurl_test
+
+// raceEnabled is true when the race detector is enabled, which
+// makes allocation counts unreliable
+const raceEnabled = true
@@ -0,0 +1,239 @@
+package dataurl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Sniffer detects the media type of a piece of data. Sniff must return
+// a valid media type, which may include parameters such as the charset.
+//
+// Sniffers only receive the first 512 bytes of the data.
+type Sniffer interface {
+	Sniff(data []byte) string
+}
+
+// SnifferFunc is an adapter to allow the use of ordinary functions
+// as a Sniffer.
+type SnifferFunc func([]byte) string
+
+// Sniff calls f(data)
+func (f SnifferFunc) Sniff(data []byte) string {
+	return f(data)
+}
+
+// HTTPSniffer is a Sniffer that uses `"net/http".DetectContentType`.
+// This is the Sniffer that is used by default.
+type HTTPSniffer struct{}
+
+// Sniff detects the media type of data using `"net/http".DetectContentType`
+func (HTTPSniffer) Sniff(data []byte) string {
+	return http.DetectContentType(data)
+}
+
+// sniff detects the media type of data using sniffer, or HTTPSniffer
+// if sniffer is nil. Only the first sniffLen bytes of data are passed
+// to the sniffer, even when the entire data is available.
+func sniff(sniffer Sniffer, data []byte) string {
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+	if sniffer == nil {
+		return http.DetectContentType(data)
+	}
+	return sniffer.Sniff(data)
+}
+
+// WebSniffer is a Sniffer that recognizes formats commonly used on the
+// web, in addition to the formats that HTTPSniffer recognizes.
+//
+// Binary formats (WOFF, WOFF2, AVIF, HEIC, WebP, ICO, WebAssembly and
+// PDF) are recognized from their magic bytes. Text formats (SVG, JSON
+// and CSS) are recognized using light structural checks, which do not
+// require the data to be complete.
+type WebSniffer struct{}
+
+// webSignature is a sequence of magic bytes at the beginning of the data
+type webSignature struct {
+	prefix    string
+	mediaType string
+}
+
+var webSignatures = []webSignature{
+	{prefix: "wOFF", mediaType: `font/woff`},
+	{prefix: "wOF2", mediaType: `font/woff2`},
+	{prefix: "\x00\x00\x01\x00", mediaType: `image/x-icon`},
+	{prefix: "\x00asm", mediaType: `application/wasm`},
+	{prefix: "%PDF-", mediaType: `application/pdf`},
+}
+
+// Sniff detects the media type of data
+func (WebSniffer) Sniff(data []byte) string {
+	for _, sig := range webSignatures {
+		if bytes.HasPrefix(data, []byte(sig.prefix)) {
+			return sig.mediaType
+		}
+	}
+	if mt := sniffWebP(data); mt != "" {
+		return mt
+	}
+	if mt := sniffISOBMFF(data); mt != "" {
+		return mt
+	}
+
+	detected := http.DetectContentType(data)
+	if !strings.HasPrefix(detected, `text/plain`) && !strings.HasPrefix(detected, `text/xml`) {
+		return detected
+	}
+	// keep the charset that was detected, if any
+	params := detected[strings.IndexByte(detected+`;`, ';'):]
+
+	text := trimLeftSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case isSVG(text):
+		return `image/svg+xml`
+	case strings.HasPrefix(detected, `text/xml`):
+		return detected
+	case isJSON(text):
+		return `application/json`
+	case isCSS(text):
+		return `text/css` + params
+	}
+	return detected
+}
+
+func sniffWebP(data []byte) string {
+	if len(data) >= 12 && bytes.HasPrefix(data, []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")) {
+		return `image/webp`
+	}
+	return ""
+}
+
+// sniffISOBMFF recognizes AVIF and HEIC images, which are stored in the
+// ISO base media file format, from the brands in the "ftyp" box
+func sniffISOBMFF(data []byte) string {
+	if len(data) < 16 || !bytes.Equal(data[4:8], []byte("ftyp")) {
+		return ""
+	}
+
+	size := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if size < 16 || size%4 != 0 {
+		return ""
+	}
+	if size > len(data) {
+		size = len(data) - len(data)%4
+	}
+
+	// the major brand, followed by the minor version and the
+	// compatible brands
+	brands := [][]byte{data[8:12]}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, data[i:i+4])
+	}
+
+	var heic bool
+	for _, brand := range brands {
+		switch string(brand) {
+		case "avif", "avis":
+			return `image/avif`
+		case "heic", "heix", "heim", "heis":
+			heic = true
+		}
+	}
+	if heic {
+		return `image/heic`
+	}
+	return ""
+}
+
+// isSVG returns true if the root element of text, which must be XML
+// or HTML-like markup, is "svg"
+func isSVG(text []byte) bool {
+	for {
+		text = trimLeftSpace(text)
+		var end []byte
+		switch {
+		case bytes.HasPrefix(text, []byte("<?")):
+			end = []byte("?>")
+		case bytes.HasPrefix(text, []byte("<!--")):
+			end = []byte("-->")
+		case bytes.HasPrefix(text, []byte("<!")):
+			end = []byte(">")
+		default:
+			return bytes.HasPrefix(text, []byte("<svg")) && len(text) > 4 && (isSpace(text[4]) || text[4] == '>' || text[4] == '/')
+		}
+
+		i := bytes.Index(text, end)
+		if i < 0 {
+			return false
+		}
+		text = text[i+len(end):]
+	}
+}
+
+// isJSON returns true if text starts with a JSON object or array.
+// As text may be truncated, it only has to be valid up to its end.
+func isJSON(text []byte) bool {
+	if len(text) == 0 || (text[0] != '{' && text[0] != '[') {
+		return false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(text))
+	for {
+		_, err := dec.Token()
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// cssAtRules are the at-rules that are likely to appear at the
+// beginning of a stylesheet
+var cssAtRules = []string{`@charset`, `@import`, `@namespace`, `@media`, `@font-face`, `@supports`, `@layer`, `@keyframes`, `@page`}
+
+// isCSS returns true if text looks like a stylesheet, that is, if it
+// starts with a common at-rule or with a rule such as `body { margin: 0 }`
+func isCSS(text []byte) bool {
+	// skip leading comments
+	for bytes.HasPrefix(text, []byte("/*")) {
+		i := bytes.Index(text, []byte("*/"))
+		if i < 0 {
+			return false
+		}
+		text = trimLeftSpace(text[i+2:])
+	}
+
+	for _, rule := range cssAtRules {
+		if bytes.HasPrefix(text, []byte(rule)) {
+			return true
+		}
+	}
+
+	i := bytes.IndexByte(text, '{')
+	if i < 0 {
+		return false
+	}
+	selector := trimSpace(text[:i])
+	if len(selector) == 0 || bytes.ContainsAny(selector, `;={}<"'`) {
+		return false
+	}
+
+	// the body must be empty, or start with a declaration
+	body := trimLeftSpace(text[i+1:])
+	if len(body) > 0 && body[0] == '}' {
+		return true
+	}
+	var j int
+	for j < len(body) && (isAlnum(body[j]) || body[j] == '-') {
+		j++
+	}
+	return j > 0 && bytes.HasPrefix(trimLeftSpace(body[j:]), []byte(":"))
+}
@@ -0,0 +1,172 @@
+package dataurl
+
+import (
+	"bytes"
+)
+
+// lowerHex is used for the escaped sequences in SVG data URLs, as lower
+// case sequences compress better along with the surrounding markup
+const lowerHex = `0123456789abcdef`
+
+var svgHeader = []byte(`data:image/svg+xml,`)
+
+// EncodeSVG encodes an SVG image into the compact, percent-encoded form
+// of data URL that is widely known as the "mini SVG data URI". This is
+// usually much shorter than both Encode and base64 encoding.
+//
+// The markup is minified before it is encoded:
+//
+//   - runs of whitespace are collapsed into a single space, and leading
+//     and trailing whitespace is removed. This is skipped if the image
+//     uses the `xml:space` attribute, and never applies to CDATA sections
+//     or to the contents of `<script>` and `<style>` elements.
+//   - attribute values that are delimited by double quotes are delimited
+//     by single quotes instead, unless they contain a single quote.
+//
+// Only the characters that break a double-quoted CSS `url("...")` or
+// HTML attribute value are escaped, along with the ones that URL parsers
+// would not keep as is. Spaces, single quotes, `=`, `:` and `/` are
+// left unescaped, so the result must be parsed with ParseModeLenient or
+// ParseModeWHATWG, as web browsers do.
+func EncodeSVG(data []byte) []byte {
+	svg := minifySVG(data)
+
+	dst := make([]byte, 0, len(svgHeader)+len(svg)+len(svg)/4)
+	dst = append(dst, svgHeader...)
+	for _, b := range svg {
+		if isSVGSafe(b) {
+			dst = append(dst, b)
+			continue
+		}
+		dst = append(dst, '%', lowerHex[b>>4], lowerHex[b&0xf])
+	}
+	return dst
+}
+
+// isSVGSafe returns true if b is left unescaped by EncodeSVG. These
+// are the characters that "encodeURIComponent" in JavaScript leaves
+// unescaped, along with the space, '=', ':' and '/' characters, which
+// web browsers accept in data URLs.
+func isSVGSafe(b byte) bool {
+	return isNotReserved(b) || b == ' ' || b == '=' || b == ':' || b == '/'
+}
+
+// minifySVG collapses the whitespace in data and swaps the quotes around
+// attribute values, as described in EncodeSVG
+func minifySVG(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	collapse := !bytes.Contains(data, []byte(`xml:space`))
+
+	dst := make([]byte, 0, len(data))
+	var inTag bool
+	var tagStart int
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case collapse && isSpace(c):
+			for i+1 < len(data) && isSpace(data[i+1]) {
+				i++
+			}
+			dst = append(dst, ' ')
+			continue
+		case !inTag && c == '<':
+			if bytes.HasPrefix(data[i:], []byte(`<![CDATA[`)) {
+				n := sectionLen(data[i:], []byte(`]]>`))
+				dst = append(dst, data[i:i+n]...)
+				i += n - 1
+				continue
+			}
+			if bytes.HasPrefix(data[i:], []byte(`<!--`)) {
+				n := sectionLen(data[i:], []byte(`-->`))
+				dst = appendMinifiedText(dst, data[i:i+n], collapse)
+				i += n - 1
+				continue
+			}
+			inTag = true
+			tagStart = i
+		case inTag && c == '>':
+			inTag = false
+			// scripts and style sheets are kept as is, as whitespace
+			// may be significant in them
+			if end := rawTextEnd(data[tagStart:i]); end != nil && data[i-1] != '/' {
+				n := bytes.Index(data[i+1:], end)
+				if n < 0 {
+					return append(dst, data[i:]...)
+				}
+				dst = append(dst, data[i:i+1+n]...)
+				i += n
+				continue
+			}
+		case inTag && (c == '"' || c == '\''):
+			n := sectionLen(data[i+1:], []byte{c})
+			value := data[i+1 : i+1+n]
+			if !bytes.HasSuffix(value, []byte{c}) {
+				// the value is not terminated, so keep the rest as is
+				return append(dst, data[i:]...)
+			}
+			value = value[:len(value)-1]
+
+			quote := c
+			if bytes.IndexByte(value, '\'') < 0 {
+				quote = '\''
+			}
+			dst = append(dst, quote)
+			dst = appendMinifiedText(dst, value, collapse)
+			dst = append(dst, quote)
+			i += n
+			continue
+		}
+		dst = append(dst, c)
+	}
+	return trimSpace(dst)
+}
+
+// rawTextEnd returns the start of the closing tag of the element that
+// tag opens if its contents must be kept as is, and nil otherwise. tag
+// starts with '<' and does not include the closing '>'.
+func rawTextEnd(tag []byte) []byte {
+	name := tag[1:]
+	for i, c := range name {
+		if isSpace(c) || c == '/' {
+			name = name[:i]
+			break
+		}
+	}
+	switch string(name) {
+	case `script`:
+		return []byte(`</script`)
+	case `style`:
+		return []byte(`</style`)
+	}
+	return nil
+}
+
+// sectionLen returns the length of the section at the beginning of data
+// that ends with end, including end. If end is not found, the length of
+// data is returned.
+func sectionLen(data, end []byte) int {
+	i := bytes.Index(data, end)
+	if i < 0 {
+		return len(data)
+	}
+	return i + len(end)
+}
+
+// appendMinifiedText appends text to dst, collapsing runs of whitespace
+// into a single space if collapse is true
+func appendMinifiedText(dst, text []byte, collapse bool) []byte {
+	if !collapse {
+		return append(dst, text...)
+	}
+	for i := 0; i < len(text); i++ {
+		if !isSpace(text[i]) {
+			dst = append(dst, text[i])
+			continue
+		}
+		for i+1 < len(text) && isSpace(text[i+1]) {
+			i++
+		}
+		dst = append(dst, ' ')
+	}
+	return dst
+}
@@ -0,0 +1,167 @@
+package dataurl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+)
+
+// source holds the state of a URL right after it was parsed, so that
+// the original input can be reproduced as long as the URL is not modified
+type source struct {
+	raw       []byte // copy of the original input
+	payload   int    // position of the payload within raw
+	variant   Base64Variant
+	mediaType MediaType
+	base64    bool
+	data      []byte // copy of the data right after parsing
+}
+
+// record remembers data as the original input of u, which was obtained
+// by parsing data using p
+func (u *URL) record(p *parser, data []byte) {
+	// parsing has succeeded, so there is a comma after the scheme
+	start := bytes.IndexByte(data, ':') + 1
+	end := start + bytes.IndexByte(data[start:], ',')
+	u.Header = string(data[start:end])
+
+	u.source = &source{
+		raw:     append([]byte(nil), data...),
+		payload: end + 1,
+		variant: p.variant,
+		mediaType: MediaType{
+			Type:   u.MediaType.Type,
+			Params: copyParams(u.MediaType.Params),
+		},
+		base64: u.Base64,
+		data:   append([]byte(nil), u.Data...),
+	}
+}
+
+// reproduce returns the original input if u has not been modified since
+// it was parsed. If only the data has been modified, the original header
+// is kept and only the payload is encoded again. The second return value
+// is false if the media type or the encoding has been modified.
+func (u URL) reproduce() ([]byte, bool) {
+	src := u.source
+	if src == nil || u.Base64 != src.base64 || u.MediaType.Type != src.mediaType.Type || !equalParams(u.MediaType.Params, src.mediaType.Params) {
+		return nil, false
+	}
+
+	if bytes.Equal(u.Data, src.data) {
+		return append([]byte(nil), src.raw...), true
+	}
+
+	dst := append([]byte(nil), src.raw[:src.payload]...)
+	if !u.Base64 {
+		return appendEscaped(dst, u.Data, EscapeProfileStrict), true
+	}
+	return appendBase64Encoded(src.variant.encoding(), dst, u.Data), true
+}
+
+func copyParams(params map[string]string) map[string]string {
+	dst := make(map[string]string, len(params))
+	for k, v := range params {
+		dst[k] = v
+	}
+	return dst
+}
+
+func equalParams(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// IsA reports whether the media type of u matches any of patterns.
+// See MediaType.Match for the supported patterns.
+func (u *URL) IsA(patterns ...string) bool {
+	for _, pattern := range patterns {
+		if u.MediaType.Match(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the data URL in its serialized form.
+//
+// If u was obtained from Parse with WithPreserveInput and has not been
+// modified since, the original input is returned as is. If only u.Data has been modified,
+// the original header is kept. Otherwise the URL is encoded again: the
+// payload is base64 encoded if u.Base64 is true, and percent-escaped
+// otherwise. If the media type is empty, it is detected from the data
+// as Encode does.
+//
+// If the URL cannot be serialized, an empty string is returned. Use
+// MarshalText to obtain the error.
+func (u URL) String() string {
+	text, err := u.MarshalText()
+	if err != nil {
+		return ""
+	}
+	return string(text)
+}
+
+// MarshalText implements encoding.TextMarshaler. The result is the
+// same as String.
+func (u URL) MarshalText() ([]byte, error) {
+	if text, ok := u.reproduce(); ok {
+		return text, nil
+	}
+
+	if u.MediaType.Type == "" {
+		options := []EncodeOption{WithBase64Encoding(u.Base64)}
+		if len(u.MediaType.Params) > 0 {
+			options = append(options, WithOrderedParams(u.MediaType.OrderedParams()))
+		}
+		return Encode(u.Data, options...)
+	}
+
+	// the media type is formatted as is, rather than passed to Encode,
+	// so that the order of the parameters is kept
+	dst := append([]byte(nil), scheme...)
+	dst, err := u.MediaType.append(dst, true)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to format media type: %w`, err)
+	}
+	if u.Base64 {
+		dst = append(dst, base64Marker...)
+		dst = append(dst, ',')
+		return appendBase64Encoded(base64.StdEncoding, dst, u.Data), nil
+	}
+	dst = append(dst, ',')
+	return appendEscapedPayload(dst, u.Data, EscapeProfileStrict), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. The text is parsed
+// using Parse with the default options, so the input is not preserved:
+// marshaling the URL again encodes it from its fields, which may not
+// reproduce text byte for byte. Use Parse with WithPreserveInput to keep
+// the original form.
+func (u *URL) UnmarshalText(text []byte) error {
+	parsed, err := Parse(text)
+	if err != nil {
+		return err
+	}
+	*u = *parsed
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. The result is the
+// same as MarshalText.
+func (u URL) MarshalBinary() ([]byte, error) {
+	return u.MarshalText()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. The data is
+// parsed in the same way as UnmarshalText.
+func (u *URL) UnmarshalBinary(data []byte) error {
+	return u.UnmarshalText(data)
+}
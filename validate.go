@@ -0,0 +1,417 @@
+package dataurl
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This file implements the validation of media types that AppendDecode
+// performs instead of parsing them. Each function accepts what the
+// corresponding parser accepts, and reports the same kind of error at
+// the same position, but does not allocate any memory unless the media
+// type is invalid.
+//
+// In the default mode, media types are parsed by "mime".ParseMediaType,
+// so checkDefaultMediaType mirrors the following of its behaviors, which
+// decide whether a media type is accepted:
+//
+//   - the type and subtype are tokens, surrounded by optional whitespace
+//   - whitespace, as defined by unicode.IsSpace, is allowed around ';',
+//     '=' and the parameter names
+//   - a value is either a token or a quoted string, in which a backslash
+//     only escapes the characters in tspecials, and where CR and LF are
+//     not allowed
+//   - a semicolon that is only followed by whitespace is ignored
+//   - parameter names are case-insensitive, and a parameter may only be
+//     repeated with the same value
+//
+// RFC 2231 parameters, whose names contain '*', are combined and decoded
+// by "mime".ParseMediaType, and non-ASCII text affects how whitespace is
+// trimmed, so media types that contain either are parsed instead. These
+// behaviors have changed across Go releases, which is why the checks are
+// compared against Parse over all the test fixtures.
+
+// checkMediaType checks header in the same way as parseHeader does.
+// header must not include the ";base64" marker. offset is the position
+// of header within the original input.
+func (p *parser) checkMediaType(header []byte, offset int) error {
+	switch p.mode {
+	case ParseModeStrict:
+		return checkStrictMediaType(header, offset)
+	case ParseModeDefault:
+		// RFC 2231 parameters and non-ASCII text are decoded by
+		// "mime".ParseMediaType in ways that are not worth replicating,
+		// so such media types are parsed instead
+		for _, c := range header {
+			if c == '*' || c >= utf8.RuneSelf {
+				_, err := parseDefaultMediaType(header, offset)
+				return err
+			}
+		}
+		return checkDefaultMediaType(header, offset)
+	default:
+		// the lenient modes fall back to the default media type
+		return nil
+	}
+}
+
+// checkDefaultMediaType checks header in the same way as
+// parseDefaultMediaType does. header must only contain ASCII text, and
+// must not contain RFC 2231 parameters.
+func checkDefaultMediaType(header []byte, offset int) error {
+	if len(header) == 0 {
+		return nil
+	}
+
+	base := header
+	if i := bytes.IndexByte(header, ';'); i > -1 {
+		base = header[:i]
+	}
+	if err := checkMIMEType(bytes.TrimSpace(base)); err != nil {
+		return newParseError(KindInvalidMediaType, offset, fmt.Errorf(`failed to parse media type %q: %w`, header, err))
+	}
+
+	params := header[len(base):]
+	for rest := params; ; {
+		key, value, next, ok := nextMIMEParam(rest)
+		if !ok {
+			// trailing semicolons are ignored
+			if next = bytes.TrimSpace(next); len(next) > 0 && !(len(next) == 1 && next[0] == ';') {
+				return newParseError(KindInvalidMediaType, offset, fmt.Errorf(`failed to parse media type %q: %w`, header, mime.ErrInvalidMediaParameter))
+			}
+			break
+		}
+
+		// duplicate parameters are accepted if their values are equal
+		for prev := params; len(prev) > len(rest); {
+			k, v, n, _ := nextMIMEParam(prev)
+			if bytes.EqualFold(k, key) && !equalMIMEValues(v, value) {
+				return newParseError(KindInvalidMediaType, offset, fmt.Errorf(`failed to parse media type %q: duplicate parameter %q`, header, key))
+			}
+			prev = n
+		}
+		rest = next
+	}
+
+	// as in unescapeParams, the escaped sequences are checked once all
+	// the parameters have been found
+	for rest := params; ; {
+		key, value, next, ok := nextMIMEParam(rest)
+		if !ok {
+			break
+		}
+		if err := checkEscapes(key, offset, nil); err != nil {
+			return atOffset(err, offset)
+		}
+		// backslashes only escape special characters, which are not
+		// hexadecimal digits, so the escaped sequences are the same
+		// whether or not a quoted string is unquoted
+		if value[0] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		if err := checkEscapes(value, offset, nil); err != nil {
+			return atOffset(err, offset)
+		}
+		rest = next
+	}
+
+	// parameters that are the same once unescaped are duplicates
+	for rest := params; ; {
+		key, _, next, ok := nextMIMEParam(rest)
+		if !ok {
+			return nil
+		}
+		for other := next; ; {
+			k, _, n, ok := nextMIMEParam(other)
+			if !ok {
+				break
+			}
+			if !bytes.EqualFold(k, key) && equalUnescapedNames(k, key) {
+				return newParseError(KindInvalidMediaType, offset, fmt.Errorf(`duplicate parameter %q`, key))
+			}
+			other = n
+		}
+		rest = next
+	}
+}
+
+// checkMIMEType checks the media type without its parameters in the
+// same way as "mime".ParseMediaType does
+func checkMIMEType(typ []byte) error {
+	typ, rest := consumeMIMEToken(typ)
+	if len(typ) == 0 {
+		return fmt.Errorf(`no media type`)
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	if rest[0] != '/' {
+		return fmt.Errorf(`expected slash after first token`)
+	}
+	subtype, rest := consumeMIMEToken(rest[1:])
+	if len(subtype) == 0 {
+		return fmt.Errorf(`expected token after slash`)
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf(`unexpected content after media subtype`)
+	}
+	return nil
+}
+
+// consumeMIMEToken splits v after the token that it starts with
+func consumeMIMEToken(v []byte) ([]byte, []byte) {
+	for i, c := range v {
+		if !isTokenChar(c) {
+			return v[:i], v[i:]
+		}
+	}
+	return v, nil
+}
+
+// nextMIMEParam returns the name and the value of the parameter that
+// v starts with, along with the rest of v, in the same way as
+// "mime".ParseMediaType consumes parameters. The value is returned as
+// it appears in v, including the quotes around quoted strings. The last
+// return value is false if v does not start with a valid parameter, in
+// which case v is returned as is.
+func nextMIMEParam(v []byte) ([]byte, []byte, []byte, bool) {
+	rest := bytes.TrimLeftFunc(v, unicode.IsSpace)
+	if len(rest) == 0 || rest[0] != ';' {
+		return nil, nil, v, false
+	}
+
+	key, rest := consumeMIMEToken(bytes.TrimLeftFunc(rest[1:], unicode.IsSpace))
+	if len(key) == 0 {
+		return nil, nil, v, false
+	}
+
+	rest = bytes.TrimLeftFunc(rest, unicode.IsSpace)
+	if len(rest) == 0 || rest[0] != '=' {
+		return nil, nil, v, false
+	}
+	rest = bytes.TrimLeftFunc(rest[1:], unicode.IsSpace)
+
+	if len(rest) == 0 || rest[0] != '"' {
+		value, rest := consumeMIMEToken(rest)
+		if len(value) == 0 {
+			return nil, nil, v, false
+		}
+		return key, value, rest, true
+	}
+
+	for i := 1; i < len(rest); i++ {
+		switch c := rest[i]; {
+		case c == '"':
+			return key, rest[:i+1], rest[i+1:], true
+		case c == '\\' && i+1 < len(rest) && isTSpecial(rest[i+1]):
+			i++ // skip the escaped character
+		case c == '\r' || c == '\n':
+			return nil, nil, v, false
+		}
+	}
+	return nil, nil, v, false
+}
+
+func isTSpecial(c byte) bool {
+	return strings.IndexByte(`()<>@,;:\"/[]?=`, c) > -1
+}
+
+// equalMIMEValues reports whether the parameter values a and b, as
+// returned by nextMIMEParam, are equal once they have been unquoted
+func equalMIMEValues(a, b []byte) bool {
+	var i, j int
+	for {
+		c, n, ok := nextMIMEValueByte(a, i)
+		d, m, ok2 := nextMIMEValueByte(b, j)
+		if !ok || !ok2 {
+			return ok == ok2
+		}
+		if c != d {
+			return false
+		}
+		i, j = n, m
+	}
+}
+
+// nextMIMEValueByte returns the byte of value at position i once value
+// has been unquoted, along with the position of the next byte. The last
+// return value is false at the end of value.
+func nextMIMEValueByte(value []byte, i int) (byte, int, bool) {
+	if value[0] != '"' {
+		if i >= len(value) {
+			return 0, i, false
+		}
+		return value[i], i + 1, true
+	}
+
+	if i == 0 {
+		i = 1 // skip the opening quote
+	}
+	end := len(value) - 1 // position of the closing quote
+	if i >= end {
+		return 0, i, false
+	}
+	if value[i] == '\\' && i+1 < end && isTSpecial(value[i+1]) {
+		return value[i+1], i + 2, true
+	}
+	return value[i], i + 1, true
+}
+
+// equalUnescapedNames reports whether the parameter names a and b are
+// equal once they have been converted to lower case and then unescaped,
+// as unescapeParams does with the names returned by "mime".ParseMediaType.
+// Both must only contain valid escaped sequences.
+func equalUnescapedNames(a, b []byte) bool {
+	var i, j int
+	for i < len(a) && j < len(b) {
+		var c, d byte
+		c, i = nextLowerUnescapedByte(a, i)
+		d, j = nextLowerUnescapedByte(b, j)
+		if c != d {
+			return false
+		}
+	}
+	return i == len(a) && j == len(b)
+}
+
+// nextLowerUnescapedByte is like nextUnescapedByte, but converts bytes
+// that are not escaped to lower case
+func nextLowerUnescapedByte(data []byte, i int) (byte, int) {
+	c, n := nextUnescapedByte(data, i)
+	if data[i] != '%' && c >= 'A' && c <= 'Z' {
+		c += 'a' - 'A'
+	}
+	return c, n
+}
+
+// nextUnescapedByte returns the byte of data at position i once data
+// has been unescaped, along with the position of the next byte. data
+// must only contain valid escaped sequences.
+func nextUnescapedByte(data []byte, i int) (byte, int) {
+	if data[i] == '%' {
+		return unhex(data[i+1])<<4 | unhex(data[i+2]), i + 3
+	}
+	return data[i], i + 1
+}
+
+// checkStrictMediaType checks header in the same way as
+// parseStrictMediaType does
+func checkStrictMediaType(header []byte, offset int) error {
+	if len(header) == 0 {
+		return nil
+	}
+
+	typ := header
+	if i := bytes.IndexByte(header, ';'); i > -1 {
+		typ = header[:i]
+	}
+	if len(typ) > 0 {
+		if err := checkEscapes(typ, offset, isURLChar); err != nil {
+			return err
+		}
+		if !isUnescapedMediaType(typ) {
+			return newParseError(KindInvalidMediaType, offset, fmt.Errorf(`invalid media type %q`, typ))
+		}
+	}
+	offset += len(typ) + 1
+
+	for rest := header[len(typ):]; len(rest) > 0; {
+		token := rest[1:] // skip ';'
+		if i := bytes.IndexByte(token, ';'); i > -1 {
+			token = token[:i]
+		}
+		rest = rest[1+len(token):]
+
+		i := bytes.IndexByte(token, '=')
+		if i < 0 {
+			return newParseError(KindInvalidMediaType, offset, fmt.Errorf(`invalid media type parameter %q`, token))
+		}
+
+		key := token[:i]
+		if err := checkEscapes(key, offset, isURLChar); err != nil {
+			return err
+		}
+		if !isUnescapedToken(key) {
+			return newParseError(KindInvalidMediaType, offset, fmt.Errorf(`invalid parameter key %q`, key))
+		}
+
+		value := token[i+1:]
+		if err := checkEscapes(value, offset+i+1, isURLChar); err != nil {
+			return err
+		}
+		if !isUnescapedToken(value) && !isUnescapedQuotedString(value) {
+			return newParseError(KindInvalidMediaType, offset+i+1, fmt.Errorf(`invalid parameter value for %q`, key))
+		}
+		offset += len(token) + 1
+	}
+	return nil
+}
+
+// isUnescapedMediaType reports whether typ, once unescaped, consists
+// of two tokens separated by a slash. typ must only contain valid
+// escaped sequences.
+func isUnescapedMediaType(typ []byte) bool {
+	for i := 0; i < len(typ); {
+		start := i
+		var c byte
+		if c, i = nextUnescapedByte(typ, i); c == '/' {
+			return isUnescapedToken(typ[:start]) && isUnescapedToken(typ[i:])
+		}
+	}
+	return false
+}
+
+// isUnescapedToken is like isToken, but unescapes data first. data must
+// only contain valid escaped sequences.
+func isUnescapedToken(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	for i := 0; i < len(data); {
+		var c byte
+		if c, i = nextUnescapedByte(data, i); !isTokenChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// isUnescapedQuotedString reports whether data, once unescaped, can be
+// decoded by unquote. data must only contain valid escaped sequences.
+func isUnescapedQuotedString(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+
+	// as every '%' starts an escaped sequence, the last byte is escaped
+	// if there is a '%' two bytes before it
+	last := len(data) - 1
+	if len(data) >= 3 && data[len(data)-3] == '%' {
+		last = len(data) - 3
+	}
+
+	first, i := nextUnescapedByte(data, 0)
+	closing, _ := nextUnescapedByte(data, last)
+	if i > last || first != '"' || closing != '"' {
+		return false
+	}
+
+	for i < last {
+		var c byte
+		c, i = nextUnescapedByte(data, i)
+		switch c {
+		case '"', '\r':
+			return false
+		case '\\':
+			if i >= last {
+				return false
+			}
+			_, i = nextUnescapedByte(data, i)
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package dataurl
+
+import (
+	"fmt"
+)
+
+// Tolerance describes a combination of a declared media type and a
+// sniffed media type that Verify accepts even though they differ.
+// Both fields are patterns that are matched using MediaType.Match.
+type Tolerance struct {
+	// Declared is the pattern for the media type of the URL
+	Declared string
+	// Sniffed is the pattern for the media type detected from the data
+	Sniffed string
+}
+
+// DefaultTolerances returns the tolerance table that is used when none
+// is specified. It accepts the following combinations:
+//
+//   - `application/octet-stream` on either side matches anything, as it
+//     means that the content is unknown
+//   - sniffed `text/plain` matches any textual media type, including
+//     JSON, XML and JavaScript
+//   - sniffed `text/xml` matches any XML based media type
+func DefaultTolerances() []Tolerance {
+	return []Tolerance{
+		{Declared: `application/octet-stream`, Sniffed: `*/*`},
+		{Declared: `*/*`, Sniffed: `application/octet-stream`},
+		{Declared: `text/*`, Sniffed: `text/plain`},
+		{Declared: `application/json`, Sniffed: `text/plain`},
+		{Declared: `*/*+json`, Sniffed: `text/plain`},
+		{Declared: `application/xml`, Sniffed: `text/plain`},
+		{Declared: `*/*+xml`, Sniffed: `text/plain`},
+		{Declared: `application/javascript`, Sniffed: `text/plain`},
+		{Declared: `application/xml`, Sniffed: `text/xml`},
+		{Declared: `*/*+xml`, Sniffed: `text/xml`},
+	}
+}
+
+// MediaTypeMismatch is the error that is returned when the media type
+// detected from the data differs from the declared media type. When
+// returned from Parse, it is wrapped in a *ParseError whose Kind is
+// KindMediaTypeMismatch.
+type MediaTypeMismatch struct {
+	// Declared is the media type of the URL
+	Declared MediaType
+	// Sniffed is the media type detected from the data
+	Sniffed MediaType
+}
+
+func (e *MediaTypeMismatch) Error() string {
+	return fmt.Sprintf(`declared media type %q does not match sniffed media type %q`, e.Declared.Essence(), e.Sniffed.Essence())
+}
+
+// verifyConfig holds the configuration for verifying the content of a URL
+type verifyConfig struct {
+	tolerances    []Tolerance
+	tolerancesSet bool
+	sniffer       Sniffer
+}
+
+func (c *verifyConfig) apply(options []VerifyOption) {
+	for _, option := range options {
+		switch option.Ident() {
+		case identTolerances{}:
+			c.tolerances = option.Value().([]Tolerance)
+			c.tolerancesSet = true
+		case identSniffer{}:
+			c.sniffer = option.Value().(Sniffer)
+		}
+	}
+}
+
+// Verify detects the media type of u.Data using a Sniffer, and
+// returns a *MediaTypeMismatch if it differs from u.MediaType. Only the
+// type and the subtype are compared, and combinations listed in the
+// tolerance table are accepted. Use `dataurl.WithTolerances()` to
+// replace the default table, which is returned by DefaultTolerances,
+// and `dataurl.WithSniffer()` to replace HTTPSniffer.
+//
+// Empty data is not verified, as there is nothing to detect.
+func (u *URL) Verify(options ...VerifyOption) error {
+	var c verifyConfig
+	c.apply(options)
+	return c.verify(u.MediaType, u.Data)
+}
+
+func (c *verifyConfig) verify(declared MediaType, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	sniffed, err := ParseMediaType(sniff(c.sniffer, data))
+	if err != nil {
+		return fmt.Errorf(`failed to parse sniffed media type: %w`, err)
+	}
+	if sniffed.Essence() == declared.Essence() {
+		return nil
+	}
+
+	tolerances := c.tolerances
+	if !c.tolerancesSet {
+		tolerances = DefaultTolerances()
+	}
+	for _, t := range tolerances {
+		if declared.Match(t.Declared) && sniffed.Match(t.Sniffed) {
+			return nil
+		}
+	}
+	return &MediaTypeMismatch{Declared: declared, Sniffed: sniffed}
+}
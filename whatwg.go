@@ -0,0 +1,436 @@
+package dataurl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// This file implements the "data: URL processor" from the WHATWG Fetch
+// standard (https://fetch.spec.whatwg.org/#data-url-processor), along
+// with the parts of the URL, MIME Sniffing and Infra standards that
+// it depends on.
+
+// parseWHATWG parses data the same way web browsers do.
+//
+// As the input is normalized before being processed, errors are reported
+// at the beginning of the section of the original input that they
+// were found in.
+func (p *parser) parseWHATWG(orig []byte) (*URL, error) {
+	mimeType, isBase64, body, err := p.appendWHATWG(nil, orig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &URL{
+		MediaType: whatwgMediaType(mimeType),
+		Data:      body,
+		Base64:    isBase64,
+	}, nil
+}
+
+// appendWHATWG is the part of parseWHATWG that decodes the body, which
+// is appended to dst. The unparsed MIME type is returned along with it,
+// as well as whether the body was base64 encoded.
+//
+// The limits are checked against the original input, before it is
+// normalized, so that no memory is allocated for input that exceeds them.
+func (p *parser) appendWHATWG(dst, orig []byte) ([]byte, bool, []byte, error) {
+	if err := p.checkEncodedLength(orig); err != nil {
+		return nil, false, dst, err
+	}
+	if !hasWHATWGScheme(orig) {
+		return nil, false, dst, newParseError(KindInvalidScheme, 0, nil)
+	}
+
+	// the fragment is not part of the data URL
+	input := orig
+	if i := bytes.IndexByte(input, '#'); i > -1 {
+		input = input[:i]
+	}
+
+	comma := bytes.IndexByte(input, ',')
+	if comma < 0 {
+		return nil, false, dst, newParseError(KindNoData, len(orig), nil)
+	}
+
+	headerOffset := bytes.IndexByte(orig, ':') + 1
+	if err := p.checkHeader(orig[headerOffset:comma], headerOffset); err != nil {
+		return nil, false, dst, err
+	}
+
+	header := serializeWHATWG(orig[:comma], true)[len(scheme):]
+	mimeType, isBase64 := splitWHATWGHeader(header)
+	if p.policy != nil {
+		if err := p.checkPolicy(whatwgMediaType(mimeType), header, headerOffset); err != nil {
+			return nil, false, dst, err
+		}
+	}
+
+	payloadOffset := comma + 1
+	payload := input[payloadOffset:]
+	if len(input) == len(orig) {
+		payload = trimRightC0Space(payload)
+	}
+	if p.maxDecoded > 0 && whatwgDecodedLen(payload, isBase64) > p.maxDecoded {
+		return nil, false, dst, p.decodedTooLarge(payloadOffset)
+	}
+
+	data := serializeWHATWG(orig, false)
+	body := data[bytes.IndexByte(data, ',')+1:]
+	if !isBase64 {
+		return mimeType, false, appendUnescapedLenient(dst, body), nil
+	}
+
+	if bytes.IndexByte(body, '%') > -1 {
+		body = unescapeLenient(body)
+	}
+	dst, err := appendForgivingBase64(dst, body)
+	if err != nil {
+		return nil, false, dst, newParseError(KindInvalidBase64, payloadOffset, err)
+	}
+	return mimeType, true, dst, nil
+}
+
+// hasWHATWGScheme returns true if data starts with "data:" once it has
+// been normalized by serializeWHATWG, without allocating any memory
+func hasWHATWGScheme(data []byte) bool {
+	for len(data) > 0 && data[0] <= 0x20 {
+		data = data[1:]
+	}
+
+	var n int
+	for _, c := range data {
+		if n == len(scheme) {
+			break
+		}
+		if c == '\t' || c == '\n' || c == '\r' {
+			continue
+		}
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != scheme[n] {
+			return false
+		}
+		n++
+	}
+	return n == len(scheme)
+}
+
+// whatwgDecodedLen is like decodedLen, but excludes the tabs and line
+// breaks that the URL parser removes from the input
+func whatwgDecodedLen(payload []byte, isBase64 bool) int {
+	n := decodedLen(payload, isBase64)
+	if !isBase64 {
+		for _, c := range payload {
+			if c == '\t' || c == '\n' || c == '\r' {
+				n--
+			}
+		}
+	}
+	return n
+}
+
+// trimRightC0Space removes the trailing C0 control and space characters
+// from data, as the URL parser does
+func trimRightC0Space(data []byte) []byte {
+	for len(data) > 0 && data[len(data)-1] <= 0x20 {
+		data = data[:len(data)-1]
+	}
+	return data
+}
+
+// splitWHATWGHeader takes the serialized section of the input between
+// the scheme and the comma, and returns the MIME type. It also reports
+// whether the body is base64 encoded.
+func splitWHATWGHeader(header []byte) ([]byte, bool) {
+	return cutWHATWGBase64Marker(trimSpace(header))
+}
+
+// whatwgMediaType parses mimeType, falling back to the default media type
+func whatwgMediaType(mimeType []byte) MediaType {
+	s := string(mimeType)
+	if strings.HasPrefix(s, `;`) {
+		s = `text/plain` + s
+	}
+
+	mt, ok := parseWHATWGMediaType(s)
+	if !ok {
+		return defaultMediaType()
+	}
+	mt.recordOrder([]byte(s), nil)
+	return mt
+}
+
+// serializeWHATWG emulates the effect of running data through the
+// URL parser and then the URL serializer with the fragment excluded.
+// If partial is true, data is only the beginning of the input, so
+// trailing spaces and control characters are preserved.
+func serializeWHATWG(data []byte, partial bool) []byte {
+	for len(data) > 0 && data[0] <= 0x20 {
+		data = data[1:]
+	}
+	if !partial {
+		data = trimRightC0Space(data)
+	}
+
+	dst := make([]byte, 0, len(data))
+	var inQuery bool
+	for _, c := range data {
+		switch {
+		case c == '\t' || c == '\n' || c == '\r':
+			continue
+		case c == '#':
+			return dst
+		case c == '?':
+			inQuery = true
+		case c < 0x20 || c > 0x7e || (inQuery && (c == ' ' || c == '"' || c == '<' || c == '>')):
+			dst = append(dst, '%', upperHex[c>>4], upperHex[c&0xf])
+			continue
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// cutWHATWGBase64Marker checks if mimeType ends with ";base64", with
+// optional spaces in between, and returns mimeType without the marker
+func cutWHATWGBase64Marker(mimeType []byte) ([]byte, bool) {
+	const marker = `base64`
+	if len(mimeType) < len(marker) || !bytes.EqualFold(mimeType[len(mimeType)-len(marker):], []byte(marker)) {
+		return mimeType, false
+	}
+
+	trimmed := mimeType[:len(mimeType)-len(marker)]
+	for len(trimmed) > 0 && trimmed[len(trimmed)-1] == ' ' {
+		trimmed = trimmed[:len(trimmed)-1]
+	}
+	if len(trimmed) == 0 || trimmed[len(trimmed)-1] != ';' {
+		return mimeType, false
+	}
+	return trimmed[:len(trimmed)-1], true
+}
+
+// appendForgivingBase64 implements the "forgiving-base64 decode"
+// algorithm from the WHATWG Infra standard, and appends the result to
+// dst. If an error occurs, dst is returned as is.
+func appendForgivingBase64(dst, data []byte) ([]byte, error) {
+	var n int // number of characters, excluding whitespace
+	for _, c := range data {
+		if !isSpace(c) {
+			n++
+		}
+	}
+
+	// remove up to two trailing '=' characters
+	end := len(data)
+	if n%4 == 0 {
+		for i := 0; i < 2; i++ {
+			j := end - 1
+			for j >= 0 && isSpace(data[j]) {
+				j--
+			}
+			if j < 0 || data[j] != '=' {
+				break
+			}
+			end = j
+			n--
+		}
+	}
+	data = data[:end]
+
+	if n%4 == 1 {
+		return dst, fmt.Errorf(`invalid length %d`, n)
+	}
+
+	for i, c := range data {
+		if !isSpace(c) && !isBase64Char(c) {
+			return dst, fmt.Errorf(`illegal character %q at byte %d`, c, i)
+		}
+	}
+
+	size := base64.RawStdEncoding.DecodedLen(n)
+	buf := grow(dst, size)
+	written, err := decodeBase64(base64.RawStdEncoding, buf[len(buf):len(buf)+size], data, true)
+	if err != nil {
+		return dst, err
+	}
+	return buf[:len(buf)+written], nil
+}
+
+func isBase64Char(c byte) bool {
+	return (c >= '0' && c <= '9') ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		c == '+' ||
+		c == '/'
+}
+
+// parseWHATWGMediaType implements the "parse a MIME type" algorithm
+// from the WHATWG MIME Sniffing standard. The second return value is
+// false if s cannot be parsed.
+func parseWHATWGMediaType(s string) (MediaType, bool) {
+	s = trimHTTPSpace(s)
+
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return MediaType{}, false
+	}
+
+	typ := s[:i]
+	if !isHTTPToken(typ) {
+		return MediaType{}, false
+	}
+	s = s[i+1:]
+
+	subtype := s
+	if i := strings.IndexByte(s, ';'); i > -1 {
+		subtype = s[:i]
+		s = s[i:]
+	} else {
+		s = ``
+	}
+
+	subtype = trimRightHTTPSpace(subtype)
+	if !isHTTPToken(subtype) {
+		return MediaType{}, false
+	}
+
+	mt := MediaType{
+		Type:   strings.ToLower(typ + `/` + subtype),
+		Params: make(map[string]string),
+	}
+
+	pos := 0
+	for pos < len(s) {
+		pos++ // skip ';'
+		for pos < len(s) && isHTTPSpace(s[pos]) {
+			pos++
+		}
+
+		start := pos
+		for pos < len(s) && s[pos] != ';' && s[pos] != '=' {
+			pos++
+		}
+		name := strings.ToLower(s[start:pos])
+
+		if pos < len(s) {
+			if s[pos] == ';' {
+				continue
+			}
+			pos++ // skip '='
+		}
+
+		if pos >= len(s) {
+			break
+		}
+
+		var value string
+		if s[pos] == '"' {
+			value, pos = collectHTTPQuotedString(s, pos)
+			for pos < len(s) && s[pos] != ';' {
+				pos++
+			}
+		} else {
+			start := pos
+			for pos < len(s) && s[pos] != ';' {
+				pos++
+			}
+			value = trimRightHTTPSpace(s[start:pos])
+			if value == `` {
+				continue
+			}
+		}
+
+		if !isHTTPToken(name) || !isHTTPQuotedStringToken(value) {
+			continue
+		}
+
+		// the first occurrence of a parameter wins
+		if _, ok := mt.Params[name]; !ok {
+			mt.Params[name] = value
+		}
+	}
+	return mt, true
+}
+
+// collectHTTPQuotedString implements the "collect an HTTP quoted string"
+// algorithm from the WHATWG Fetch standard, with the extract-value flag
+// set. s[pos] must be '"'. It returns the extracted value and the
+// position immediately after the quoted string.
+func collectHTTPQuotedString(s string, pos int) (string, int) {
+	var sb strings.Builder
+	pos++ // skip '"'
+	for {
+		start := pos
+		for pos < len(s) && s[pos] != '"' && s[pos] != '\\' {
+			pos++
+		}
+		sb.WriteString(s[start:pos])
+
+		if pos >= len(s) {
+			break
+		}
+
+		c := s[pos]
+		pos++
+		if c == '"' {
+			break
+		}
+
+		// c is a backslash
+		if pos >= len(s) {
+			sb.WriteByte('\\')
+			break
+		}
+		sb.WriteByte(s[pos])
+		pos++
+	}
+	return sb.String(), pos
+}
+
+func isHTTPSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func trimHTTPSpace(s string) string {
+	for len(s) > 0 && isHTTPSpace(s[0]) {
+		s = s[1:]
+	}
+	return trimRightHTTPSpace(s)
+}
+
+func trimRightHTTPSpace(s string) string {
+	for len(s) > 0 && isHTTPSpace(s[len(s)-1]) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+func isHTTPToken(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			continue
+		}
+		if strings.IndexByte("!#$%&'*+-.^_`|~", c) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func isHTTPQuotedStringToken(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\t' && (c < 0x20 || c == 0x7f) {
+			return false
+		}
+	}
+	return true
+}